@@ -0,0 +1,279 @@
+package aws
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xoba/goutil/aws4"
+)
+
+var (
+	// where AssumeRole is sent
+	stsEndpoint = "https://sts.amazonaws.com"
+	stsClient   = &http.Client{Timeout: 30 * time.Second}
+)
+
+// how long before they lapse that role credentials are renewed
+const refreshWindow = 5 * time.Minute
+
+type assumeRoleResponse struct {
+	Credentials struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      time.Time
+	} `xml:"AssumeRoleResult>Credentials"`
+}
+
+type stsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// temporary credentials for roleARN, got from sts with source's. a zero
+// duration leaves it to sts, which gives an hour.
+func AssumeRole(source Auth, roleARN, sessionName, externalID string, duration time.Duration) (Auth, error) {
+	form := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {roleARN},
+		"RoleSessionName": {sessionName},
+	}
+	if externalID != "" {
+		form.Set("ExternalId", externalID)
+	}
+	if duration > 0 {
+		form.Set("DurationSeconds", strconv.Itoa(int(duration/time.Second)))
+	}
+	req, err := http.NewRequest("POST", stsEndpoint+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return Auth{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if source.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", source.SessionToken)
+	}
+	svc := aws4.Service{Name: "sts", Region: "us-east-1"}
+	if err := svc.Sign(&aws4.Keys{AccessKey: source.AccessKey, SecretKey: source.SecretKey}, req); err != nil {
+		return Auth{}, err
+	}
+	resp, err := stsClient.Do(req)
+	if err != nil {
+		return Auth{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Auth{}, err
+	}
+	if resp.StatusCode != 200 {
+		var e stsError
+		if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+			return Auth{}, fmt.Errorf("can't assume %s: %s: %s: %s", roleARN, resp.Status, e.Code, e.Message)
+		}
+		return Auth{}, fmt.Errorf("can't assume %s: %s", roleARN, resp.Status)
+	}
+	var r assumeRoleResponse
+	if err := xml.Unmarshal(body, &r); err != nil {
+		return Auth{}, fmt.Errorf("can't parse credentials for %s: %w", roleARN, err)
+	}
+	c := r.Credentials
+	if c.AccessKeyId == "" || c.SecretAccessKey == "" {
+		return Auth{}, fmt.Errorf("sts returned no credentials for %s", roleARN)
+	}
+	return Auth{AccessKey: c.AccessKeyId, SecretKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expiration: c.Expiration, Region: source.Region}, nil
+}
+
+// the credentials of a role, assumed afresh whenever the last ones are near lapsing
+type RoleCredentials struct {
+	// the credentials the role is assumed with, which may be another role's
+	Source      func() (Auth, error)
+	RoleARN     string
+	SessionName string
+	ExternalID  string
+	// how long each set of credentials lasts; sts's default when zero
+	Duration time.Duration
+
+	mu   sync.Mutex
+	auth Auth
+}
+
+// the current credentials, assuming the role again if they're within a few
+// minutes of lapsing
+func (r *RoleCredentials) Retrieve() (Auth, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.auth.AccessKey != "" && !expiring(r.auth) {
+		return r.auth, nil
+	}
+	source, err := r.Source()
+	if err != nil {
+		return Auth{}, err
+	}
+	name := r.SessionName
+	if name == "" {
+		name = fmt.Sprintf("goutil-%d", time.Now().Unix())
+	}
+	a, err := AssumeRole(source, r.RoleARN, name, r.ExternalID, r.Duration)
+	if err != nil {
+		return Auth{}, err
+	}
+	r.auth = a
+	return a, nil
+}
+
+func (r *RoleCredentials) IsExpired() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.auth.AccessKey == "" || expiring(r.auth)
+}
+
+// the role a profile names with role_arn, assumed with the credentials of its
+// source_profile, which may be a role itself, or those of its
+// credential_source: Environment or Ec2InstanceMetadata
+func RoleFromProfile(profile string) (*RoleCredentials, error) {
+	return roleFromProfile(profile, map[string]bool{})
+}
+
+func roleFromProfile(profile string, seen map[string]bool) (*RoleCredentials, error) {
+	if seen[profile] {
+		return nil, fmt.Errorf("profile %q is its own source", profile)
+	}
+	seen[profile] = true
+	p, err := loadProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if p["role_arn"] == "" {
+		return nil, fmt.Errorf("profile %q has no role_arn", profile)
+	}
+	r := &RoleCredentials{RoleARN: p["role_arn"], SessionName: p["role_session_name"], ExternalID: p["external_id"]}
+	if d := p["duration_seconds"]; d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: bad duration_seconds %q", profile, d)
+		}
+		r.Duration = time.Duration(n) * time.Second
+	}
+	region := p["region"]
+	withRegion := func(f func() (Auth, error)) func() (Auth, error) {
+		return func() (Auth, error) {
+			a, err := f()
+			if a.Region == "" {
+				a.Region = region
+			}
+			return a, err
+		}
+	}
+	switch src := p["source_profile"]; {
+	case src != "":
+		sp, err := loadProfile(src)
+		if err != nil {
+			return nil, err
+		}
+		if sp["role_arn"] != "" && src != profile {
+			parent, err := roleFromProfile(src, seen)
+			if err != nil {
+				return nil, err
+			}
+			r.Source = withRegion(parent.Retrieve)
+		} else {
+			r.Source = withRegion(func() (Auth, error) { return staticProfileAuth(src, sp) })
+		}
+	case p["credential_source"] == "Environment":
+		r.Source = withRegion(AuthFromEnv)
+	case p["credential_source"] == "Ec2InstanceMetadata" || p["credential_source"] == "EcsContainer":
+		r.Source = withRegion(AuthFromInstanceMetadata)
+	default:
+		return nil, fmt.Errorf("profile %q has a role_arn but no source_profile or known credential_source", profile)
+	}
+	return r, nil
+}
+
+// the keys held directly by a profile's settings
+func staticProfileAuth(profile string, p map[string]string) (Auth, error) {
+	a := Auth{
+		AccessKey:    p["aws_access_key_id"],
+		SecretKey:    p["aws_secret_access_key"],
+		SessionToken: p["aws_session_token"],
+		Region:       p["region"],
+	}
+	if a.AccessKey == "" || a.SecretKey == "" {
+		return Auth{}, fmt.Errorf("profile %q lacks aws_access_key_id or aws_secret_access_key", profile)
+	}
+	return a, nil
+}
+
+// a profile's settings from the config file, where it's "[profile name]"
+// except for the default, overlaid by those from the credentials file
+func loadProfile(profile string) (map[string]string, error) {
+	out := make(map[string]string)
+	found := false
+	section := "profile " + profile
+	if profile == "default" {
+		section = profile
+	}
+	if path, err := configFile(); err == nil {
+		if s, err := readSection(path, section); err != nil {
+			return nil, err
+		} else if s != nil {
+			found = true
+			for k, v := range s {
+				out[k] = v
+			}
+		}
+	}
+	if path, err := credentialsFile(); err == nil {
+		if s, err := readSection(path, profile); err != nil {
+			return nil, err
+		} else if s != nil {
+			found = true
+			for k, v := range s {
+				out[k] = v
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no profile %q in the aws config or credentials files", profile)
+	}
+	return out, nil
+}
+
+// a section of an ini file; nil, with no error, when the file or section doesn't exist
+func readSection(path, name string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sections, err := parseINI(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sections[name], nil
+}
+
+func configFile() (string, error) {
+	if p := os.Getenv("AWS_CONFIG_FILE"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "config"), nil
+}
@@ -0,0 +1,86 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// an sts that hands out credentials "<role>-key" for each role assumed,
+// recording which access key asked for which role
+func newSTS(t *testing.T) *[]string {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "AssumeRole" {
+			t.Errorf("got %v, %v", r.Form, err)
+		}
+		auth := r.Header.Get("Authorization")
+		i := strings.Index(auth, "Credential=")
+		key := strings.SplitN(auth[i+len("Credential="):], "/", 2)[0]
+		role := r.Form.Get("RoleArn")
+		role = role[strings.LastIndex(role, "/")+1:]
+		calls = append(calls, key+" -> "+role)
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>%s-key</AccessKeyId><SecretAccessKey>s</SecretAccessKey><SessionToken>t</SessionToken>
+<Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			role, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(srv.Close)
+	old := stsEndpoint
+	stsEndpoint = srv.URL
+	t.Cleanup(func() { stsEndpoint = old })
+	return &calls
+}
+
+func TestRoleFromProfileChain(t *testing.T) {
+	calls := newSTS(t)
+	setFiles(t, `
+[base]
+aws_access_key_id = base
+aws_secret_access_key = s
+`, `
+[profile middle]
+role_arn = arn:aws:iam::123456789012:role/middle
+source_profile = base
+[profile top]
+role_arn = arn:aws:iam::123456789012:role/top
+source_profile = middle
+region = eu-west-1
+`)
+	r, err := RoleFromProfile("top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		a, err := r.Retrieve()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.AccessKey != "top-key" || a.Region != "eu-west-1" {
+			t.Errorf("got %+v", a)
+		}
+	}
+	if got := strings.Join(*calls, ", "); got != "base -> middle, middle-key -> top" {
+		t.Errorf("assumed %s", got)
+	}
+	if r.IsExpired() {
+		t.Error("expired with an hour to go")
+	}
+}
+
+func TestRoleFromProfileLoop(t *testing.T) {
+	setFiles(t, "", `
+[profile a]
+role_arn = arn:aws:iam::123456789012:role/a
+source_profile = b
+[profile b]
+role_arn = arn:aws:iam::123456789012:role/b
+source_profile = a
+`)
+	if _, err := RoleFromProfile("a"); err == nil {
+		t.Error("no error for profiles that are each other's source")
+	}
+}
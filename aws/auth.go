@@ -1,5 +1,21 @@
 package aws
 
+import "time"
+
 type Auth struct {
 	AccessKey, SecretKey string
+	// region for signature v4, e.g. "eu-central-1"; us-east-1 when empty
+	Region string
+	// accompanies temporary credentials, such as those from sts or an instance role
+	SessionToken string
+	// when temporary credentials lapse; zero for long-lived ones
+	Expiration time.Time
+}
+
+// the configured region, defaulting to us-east-1
+func (a Auth) GetRegion() string {
+	if a.Region == "" {
+		return "us-east-1"
+	}
+	return a.Region
 }
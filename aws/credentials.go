@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optionally
+// AWS_SESSION_TOKEN, plus the region from AWS_REGION or AWS_DEFAULT_REGION if set
+func AuthFromEnv() (Auth, error) {
+	a := Auth{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Region:       os.Getenv("AWS_REGION"),
+	}
+	if a.Region == "" {
+		a.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if a.AccessKey == "" {
+		return Auth{}, errors.New("AWS_ACCESS_KEY_ID is not set")
+	}
+	if a.SecretKey == "" {
+		return Auth{}, errors.New("AWS_SECRET_ACCESS_KEY is not set")
+	}
+	return a, nil
+}
+
+// credentials from a profile in the shared credentials file, which is
+// $AWS_SHARED_CREDENTIALS_FILE or else ~/.aws/credentials, or the config file,
+// $AWS_CONFIG_FILE or else ~/.aws/config. an empty profile means $AWS_PROFILE,
+// or "default". for a profile with a role_arn, these are temporary
+// credentials for the role, as RoleFromProfile assumes it.
+func AuthFromProfile(profile string) (Auth, error) {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	p, err := loadProfile(profile)
+	if err != nil {
+		return Auth{}, err
+	}
+	if p["role_arn"] != "" {
+		r, err := RoleFromProfile(profile)
+		if err != nil {
+			return Auth{}, err
+		}
+		return r.Retrieve()
+	}
+	return staticProfileAuth(profile, p)
+}
+
+func credentialsFile() (string, error) {
+	if p := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aws", "credentials"), nil
+}
+
+// reads an ini file into its sections' key/value pairs
+func parseINI(r io.Reader) (map[string]map[string]string, error) {
+	out := make(map[string]map[string]string)
+	var section map[string]string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if out[name] == nil {
+				out[name] = make(map[string]string)
+			}
+			section = out[name]
+		default:
+			i := strings.Index(line, "=")
+			if i < 0 || section == nil {
+				return nil, fmt.Errorf("line %d: can't parse %q", n, line)
+			}
+			section[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return out, scanner.Err()
+}
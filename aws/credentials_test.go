@@ -0,0 +1,89 @@
+package aws
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthFromEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "a")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s")
+	t.Setenv("AWS_SESSION_TOKEN", "tok")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "eu-west-1")
+	a, err := AuthFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Auth{AccessKey: "a", SecretKey: "s", SessionToken: "tok", Region: "eu-west-1"}); a != want {
+		t.Errorf("got %+v, want %+v", a, want)
+	}
+	t.Setenv("AWS_REGION", "us-west-2")
+	if a, _ := AuthFromEnv(); a.Region != "us-west-2" {
+		t.Errorf("AWS_REGION: got region %q", a.Region)
+	}
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := AuthFromEnv(); err == nil {
+		t.Error("no error without a secret key")
+	}
+}
+
+// points the shared credentials and config files at temporary copies of these
+func setFiles(t *testing.T, credentials, config string) {
+	dir := t.TempDir()
+	for name, s := range map[string]string{"credentials": credentials, "config": config} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+}
+
+func TestAuthFromProfile(t *testing.T) {
+	setFiles(t, `
+# comments and blank lines are skipped
+[default]
+aws_access_key_id = da
+aws_secret_access_key = ds
+
+[work]
+aws_access_key_id=wa
+aws_secret_access_key=ws
+aws_session_token=wt
+`, `
+[default]
+region = us-east-2
+[profile work]
+region = ap-south-1
+`)
+	t.Setenv("AWS_PROFILE", "")
+	a, err := AuthFromProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Auth{AccessKey: "da", SecretKey: "ds", Region: "us-east-2"}); a != want {
+		t.Errorf("default: got %+v, want %+v", a, want)
+	}
+	t.Setenv("AWS_PROFILE", "work")
+	a, err = AuthFromProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Auth{AccessKey: "wa", SecretKey: "ws", SessionToken: "wt", Region: "ap-south-1"}); a != want {
+		t.Errorf("work: got %+v, want %+v", a, want)
+	}
+	if _, err := AuthFromProfile("nope"); err == nil {
+		t.Error("no error for a missing profile")
+	}
+}
+
+func TestParseINIErrors(t *testing.T) {
+	for _, s := range []string{"key = before any section", "[s]\nno equals sign"} {
+		if _, err := parseINI(strings.NewReader(s)); err == nil {
+			t.Errorf("parsed %q", s)
+		}
+	}
+}
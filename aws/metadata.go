@@ -0,0 +1,110 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// where ec2 and ecs serve instance and task credentials
+	metadataEndpoint = "http://169.254.169.254"
+	ecsEndpoint      = "http://169.254.170.2"
+	metadataClient   = &http.Client{Timeout: 5 * time.Second}
+)
+
+type metadataCredentials struct {
+	Code            string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// temporary credentials for the ecs task role when AWS_CONTAINER_CREDENTIALS_RELATIVE_URI
+// is set, else for the ec2 instance role via imdsv2. they lapse at the returned Expiration.
+func AuthFromInstanceMetadata() (Auth, error) {
+	var c metadataCredentials
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		if err := metadataGet(ecsEndpoint+uri, nil, &c); err != nil {
+			return Auth{}, err
+		}
+	} else {
+		token, err := metadataToken()
+		if err != nil {
+			return Auth{}, err
+		}
+		h := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+		base := metadataEndpoint + "/latest/meta-data/iam/security-credentials/"
+		var roles string
+		if err := metadataGet(base, h, &roles); err != nil {
+			return Auth{}, err
+		}
+		role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+		if role == "" {
+			return Auth{}, errors.New("instance has no iam role")
+		}
+		if err := metadataGet(base+role, h, &c); err != nil {
+			return Auth{}, err
+		}
+		if c.Code != "" && c.Code != "Success" {
+			return Auth{}, fmt.Errorf("instance credentials for %s: %s", role, c.Code)
+		}
+	}
+	if c.AccessKeyId == "" || c.SecretAccessKey == "" {
+		return Auth{}, errors.New("metadata service returned no credentials")
+	}
+	return Auth{AccessKey: c.AccessKeyId, SecretKey: c.SecretAccessKey, SessionToken: c.Token, Expiration: c.Expiration}, nil
+}
+
+// a session token for imdsv2
+func metadataToken() (string, error) {
+	req, err := http.NewRequest("PUT", metadataEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New("metadata token: " + resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+// fetches u into out: a *string takes the raw body, anything else is decoded from json
+func metadataGet(u string, h http.Header, out interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range h {
+		req.Header[k] = vs
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if s, ok := out.(*string); ok {
+		*s = string(b)
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
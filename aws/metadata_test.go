@@ -0,0 +1,61 @@
+package aws
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const metadataJSON = `{
+  "Code" : "Success",
+  "AccessKeyId" : "ia",
+  "SecretAccessKey" : "is",
+  "Token" : "it",
+  "Expiration" : "2020-01-02T03:04:05Z"
+}`
+
+func TestAuthFromInstanceMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			if r.Method != "PUT" || r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds") == "" {
+				t.Errorf("token: got %s with %v", r.Method, r.Header)
+			}
+			io.WriteString(w, "T")
+		case "/latest/meta-data/iam/security-credentials/":
+			if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "T" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			io.WriteString(w, "myrole\n")
+		case "/latest/meta-data/iam/security-credentials/myrole", "/v2/credentials/task":
+			io.WriteString(w, metadataJSON)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(m, e string) { metadataEndpoint, ecsEndpoint = m, e }(metadataEndpoint, ecsEndpoint)
+	metadataEndpoint, ecsEndpoint = srv.URL, srv.URL
+	want := Auth{AccessKey: "ia", SecretKey: "is", SessionToken: "it", Expiration: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	a, err := AuthFromInstanceMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != want {
+		t.Errorf("ec2: got %+v, want %+v", a, want)
+	}
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/v2/credentials/task")
+	a, err = AuthFromInstanceMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != want {
+		t.Errorf("ecs: got %+v, want %+v", a, want)
+	}
+}
@@ -0,0 +1,74 @@
+package aws
+
+import (
+	"sync"
+	"time"
+)
+
+// a source of credentials that may lapse and need fetching again. Retrieve is
+// called for every request made with it, so it returns the same credentials
+// until IsExpired, and only then fetches new ones.
+type CredentialProvider interface {
+	Retrieve() (Auth, error)
+	// whether the credentials Retrieve last returned have lapsed, or are
+	// about to, or none have been retrieved yet
+	IsExpired() bool
+}
+
+// the credentials load returns, held until they're within a few minutes of
+// their Expiration, when load is called again. those without one are kept for good.
+func CachingProvider(load func() (Auth, error)) CredentialProvider {
+	return &cachingProvider{load: load}
+}
+
+// credentials that never change
+func StaticProvider(a Auth) CredentialProvider {
+	return CachingProvider(func() (Auth, error) { return a, nil })
+}
+
+// credentials from AuthFromEnv, read once
+func EnvProvider() CredentialProvider {
+	return CachingProvider(AuthFromEnv)
+}
+
+// credentials from AuthFromProfile, read again once they lapse, as a role's do
+func ProfileProvider(profile string) CredentialProvider {
+	return CachingProvider(func() (Auth, error) { return AuthFromProfile(profile) })
+}
+
+// credentials from AuthFromInstanceMetadata, fetched again shortly before each set lapses
+func InstanceMetadataProvider() CredentialProvider {
+	return CachingProvider(AuthFromInstanceMetadata)
+}
+
+type cachingProvider struct {
+	load func() (Auth, error)
+	mu   sync.Mutex
+	auth Auth
+	ok   bool
+}
+
+func (p *cachingProvider) Retrieve() (Auth, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ok && !expiring(p.auth) {
+		return p.auth, nil
+	}
+	a, err := p.load()
+	if err != nil {
+		return Auth{}, err
+	}
+	p.auth, p.ok = a, true
+	return a, nil
+}
+
+func (p *cachingProvider) IsExpired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.ok || expiring(p.auth)
+}
+
+// whether temporary credentials are within refreshWindow of lapsing
+func expiring(a Auth) bool {
+	return !a.Expiration.IsZero() && time.Until(a.Expiration) <= refreshWindow
+}
@@ -0,0 +1,47 @@
+package aws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachingProvider(t *testing.T) {
+	n := 0
+	lasts := time.Hour
+	p := CachingProvider(func() (Auth, error) {
+		n++
+		return Auth{AccessKey: "a", SecretKey: "s", Expiration: time.Now().Add(lasts)}, nil
+	})
+	if !p.IsExpired() {
+		t.Error("expired before the first Retrieve")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.Retrieve(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 1 || p.IsExpired() {
+		t.Fatalf("loaded %d times, expired %v", n, p.IsExpired())
+	}
+
+	// those within the refresh window of lapsing are loaded again every time
+	lasts = time.Minute
+	n = 0
+	p = CachingProvider(p.(*cachingProvider).load)
+	p.Retrieve()
+	if !p.IsExpired() {
+		t.Error("credentials a minute from lapsing aren't expired")
+	}
+	p.Retrieve()
+	if n != 2 {
+		t.Errorf("loaded %d times, want 2", n)
+	}
+}
+
+func TestStaticProvider(t *testing.T) {
+	p := StaticProvider(Auth{AccessKey: "a", SecretKey: "s"})
+	a, err := p.Retrieve()
+	if err != nil || a.AccessKey != "a" || p.IsExpired() {
+		t.Fatal(a, err, p.IsExpired())
+	}
+}
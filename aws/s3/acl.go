@@ -0,0 +1,33 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// o's access control policy, as the xml s3 returns, to be put back as is
+func (s SmartS3) getObjectACL(ctx context.Context, o Object) ([]byte, error) {
+	u, resource, err := s.subresourceURL(o, url.Values{"acl": {""}})
+	if err != nil {
+		return nil, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		resp, err := s.do(ctx, "GET", u, resource, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+	v, err := s.retry(ctx, o.Bucket, fmt.Sprintf("get acl %v", o), f)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+func (s SmartS3) putObjectACL(ctx context.Context, o Object, policy []byte) error {
+	return s.putSubresource(ctx, o, "acl", policy)
+}
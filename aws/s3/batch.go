@@ -0,0 +1,49 @@
+package s3
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// what GetMany got for one object: its body, or why it couldn't
+type ManyResult struct {
+	Data []byte
+	Err  error
+}
+
+// fetches objects, concurrency at a time. see SmartS3.GetMany.
+func GetMany(auth aws.Auth, objects []Object, concurrency int) map[Object]ManyResult {
+	return NewClient(auth).GetMany(context.Background(), objects, concurrency)
+}
+
+// fetches objects whole, concurrency at a time (DefaultConcurrency when not
+// positive), for when there are many too small to be worth streaming. one
+// object failing doesn't stop the others; each has its own result, and those
+// not fetched before ctx was done have its error.
+func (s SmartS3) GetMany(ctx context.Context, objects []Object, concurrency int) map[Object]ManyResult {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	out := make(map[Object]ManyResult, len(objects))
+	var mu sync.Mutex
+	p := newPool(ctx, concurrency)
+	for _, o := range objects {
+		o := o
+		p.Go(func(ctx context.Context) error {
+			b, err := s.GetObjectContext(ctx, GetRequest{Object: o})
+			mu.Lock()
+			out[o] = ManyResult{Data: b, Err: err}
+			mu.Unlock()
+			return nil
+		})
+	}
+	err := p.Wait()
+	for _, o := range objects {
+		if _, ok := out[o]; !ok {
+			out[o] = ManyResult{Err: err}
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGetMany(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	var objects []Object
+	for i := 0; i < 50; i++ {
+		o := Object{"bkt", fmt.Sprint("k", i)}
+		objects = append(objects, o)
+		if i == 7 {
+			continue
+		}
+		if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: []byte(o.Key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := s.GetMany(ctx, objects, 8)
+	if len(got) != len(objects) {
+		t.Fatalf("%d results for %d objects", len(got), len(objects))
+	}
+	for i, o := range objects {
+		r := got[o]
+		if i == 7 {
+			var e *S3Error
+			if !errors.As(r.Err, &e) || e.HTTPStatusCode != http.StatusNotFound {
+				t.Errorf("missing object: got %v", r.Err)
+			}
+			continue
+		}
+		if r.Err != nil || string(r.Data) != o.Key {
+			t.Errorf("%s: got %q, %v", o.Key, r.Data, r.Err)
+		}
+	}
+}
+
+func TestGetManyCanceled(t *testing.T) {
+	s := newMockClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	objects := []Object{{"bkt", "a"}, {"bkt", "b"}}
+	for o, r := range s.GetMany(ctx, objects, 1) {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("%s: got %v", o.Key, r.Err)
+		}
+	}
+}
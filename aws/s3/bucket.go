@@ -0,0 +1,216 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// CreateBucket found the bucket already exists and belongs to the caller
+var ErrBucketAlreadyOwned = errors.New("bucket already owned by you")
+
+type BucketInfo struct {
+	Name         string
+	CreationDate time.Time
+}
+
+type listAllMyBucketsResult struct {
+	Owner   ListBucketResultOwner
+	Buckets []BucketInfo `xml:"Buckets>Bucket"`
+}
+
+// the buckets owned by the account
+func (s SmartS3) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
+	u, err := url.Parse(s.endpoint() + "/")
+	if err != nil {
+		return nil, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		var out listAllMyBucketsResult
+		err := s.doXML(ctx, "GET", u, "/", nil, nil, &out)
+		return out.Buckets, err
+	}
+	v, err := s.retry(ctx, "", "list buckets", f)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]BucketInfo), nil
+}
+
+type createBucketConfiguration struct {
+	XMLName            xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CreateBucketConfiguration"`
+	LocationConstraint string
+}
+
+// creates a bucket in region, us-east-1 when empty. if the caller already owns
+// it, the error wraps ErrBucketAlreadyOwned.
+func (s SmartS3) CreateBucket(ctx context.Context, name, region string) error {
+	u, resource, err := s.bucketURL(name)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	var h http.Header
+	if region != "" && region != "us-east-1" {
+		if body, err = xml.Marshal(createBucketConfiguration{LocationConstraint: region}); err != nil {
+			return err
+		}
+		h = http.Header{"Content-Type": {"application/xml"}}
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		resp, err := s.do(ctx, "PUT", u, resource, h, body)
+		var e *S3Error
+		if errors.As(err, &e) && e.Code == "BucketAlreadyOwnedByYou" {
+			return nil, fmt.Errorf("%w: %w", ErrBucketAlreadyOwned, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, resp.Body.Close()
+	}
+	_, err = s.retry(ctx, name, "create bucket "+name, f)
+	return err
+}
+
+// deletes a bucket, which must be empty
+func (s SmartS3) DeleteBucket(ctx context.Context, name string) error {
+	u, resource, err := s.bucketURL(name)
+	if err != nil {
+		return err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		resp, err := s.do(ctx, "DELETE", u, resource, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return nil, resp.Body.Close()
+	}
+	_, err = s.retry(ctx, name, "delete bucket "+name, f)
+	return err
+}
+
+type locationConstraint struct {
+	Region string `xml:",chardata"`
+}
+
+// the region bucket lives in
+func GetBucketLocation(auth aws.Auth, bucket string) (string, error) {
+	return NewClient(auth).GetBucketLocation(context.Background(), bucket)
+}
+
+func (s SmartS3) GetBucketLocation(ctx context.Context, bucket string) (string, error) {
+	u, resource, err := s.bucketURL(bucket)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = "location"
+	resource += "?location"
+	f := func(s SmartS3) (interface{}, error) {
+		var out locationConstraint
+		err := s.doXML(ctx, "GET", u, resource, nil, nil, &out)
+		return regionOf(out), err
+	}
+	v, err := s.retry(ctx, bucket, "get location of "+bucket, f)
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// s3 reports its original region as no constraint at all, and eu-west-1 by an old alias
+func regionOf(c locationConstraint) string {
+	switch r := strings.TrimSpace(c.Region); r {
+	case "":
+		return "us-east-1"
+	case "EU":
+		return "eu-west-1"
+	default:
+		return r
+	}
+}
+
+// checks name against s3's rules for bucket names: 3 to 63 lowercase
+// letters, digits, dots and hyphens, starting and ending with a letter or
+// digit, with no two dots together, and not looking like an ip address
+func ValidateBucketName(name string) error {
+	bad := func(why string) error {
+		return fmt.Errorf("illegal bucket name %q: %s", name, why)
+	}
+	switch {
+	case name == "":
+		return errors.New("no bucket name")
+	case len(name) < 3 || len(name) > 63:
+		return bad("must be 3 to 63 characters")
+	case strings.Contains(name, ".."):
+		return bad("has consecutive dots")
+	case net.ParseIP(name) != nil:
+		return bad("looks like an ip address")
+	case strings.HasPrefix(name, "xn--") || strings.HasSuffix(name, "-s3alias"):
+		return bad("uses a reserved prefix or suffix")
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9':
+		case c == '.' || c == '-':
+			if i == 0 || i == len(name)-1 {
+				return bad("must start and end with a letter or digit")
+			}
+		default:
+			return bad(fmt.Sprintf("has %q; only lowercase letters, digits, dots and hyphens are allowed", c))
+		}
+	}
+	return nil
+}
+
+// the url of a bucket itself, and the resource v2 signs for it
+func (s SmartS3) bucketURL(name string) (*url.URL, string, error) {
+	if err := ValidateBucketName(name); err != nil {
+		return nil, "", err
+	}
+	u, err := url.Parse(s.endpointFor(name) + "/" + name + "/")
+	if err != nil {
+		return nil, "", err
+	}
+	return u, s.hostStyle(u, name), nil
+}
+
+// a client bound to one bucket, taking keys where SmartS3 takes objects
+type Bucket struct {
+	Client SmartS3
+	Name   string
+}
+
+func (s SmartS3) Bucket(name string) Bucket {
+	return Bucket{Client: s, Name: name}
+}
+
+func (b Bucket) Object(key string) Object {
+	return Object{Bucket: b.Name, Key: key}
+}
+
+func (b Bucket) Get(ctx context.Context, key string) ([]byte, error) {
+	return b.Client.GetObjectContext(ctx, GetRequest{Object: b.Object(key)})
+}
+
+// stores data at key, with a content type guessed from the key's extension
+func (b Bucket) Put(ctx context.Context, key string, data []byte) error {
+	return b.Client.PutObjectContext(ctx, PutObjectRequest{Object: b.Object(key), Data: data})
+}
+
+func (b Bucket) Delete(ctx context.Context, key string) error {
+	return b.Client.DeleteContext(ctx, DeleteRequest{Object: b.Object(key)})
+}
+
+// every key starting with prefix, across however many pages that takes
+func (b Bucket) List(ctx context.Context, prefix string) ([]ListBucketResultContents, error) {
+	res, err := b.Client.ListAllContext(ctx, ListRequest{Bucket: b.Name, Prefix: prefix})
+	return res.Contents, err
+}
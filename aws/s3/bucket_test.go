@@ -0,0 +1,117 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestListBuckets(t *testing.T) {
+	s := newMockClient(t)
+	if err := s.CreateBucket(context.Background(), "another", ""); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.ListBuckets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name != "another" || got[1].Name != "bkt" || got[0].CreationDate.IsZero() {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestCreateDeleteBucket(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	if err := s.CreateBucket(ctx, "made-here", "eu-west-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateBucket(ctx, "made-here", ""); !errors.Is(err, ErrBucketAlreadyOwned) {
+		t.Errorf("again: got %v", err)
+	}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"made-here", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	var e *S3Error
+	if err := s.DeleteBucket(ctx, "made-here"); !errors.As(err, &e) || e.Code != "BucketNotEmpty" {
+		t.Errorf("not empty: got %v", err)
+	}
+	if err := s.DeleteContext(ctx, DeleteRequest{Object: Object{"made-here", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBucket(ctx, "made-here"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateBucket(ctx, "Bad_Name", ""); err == nil {
+		t.Error("created a bucket with an illegal name")
+	}
+}
+
+func TestValidateBucketName(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"my-bucket.example": true,
+		"a1b":               true,
+		"":                  false,
+		"ab":                false,
+		"My-Bucket":         false,
+		"-bucket":           false,
+		"bucket.":           false,
+		"my..bucket":        false,
+		"192.168.5.4":       false,
+		"xn--bucket":        false,
+		"bucket-s3alias":    false,
+		"under_score":       false,
+	} {
+		if err := ValidateBucketName(name); (err == nil) != ok {
+			t.Errorf("%q: got %v", name, err)
+		}
+	}
+}
+
+func TestGetBucketLocation(t *testing.T) {
+	var doc string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "location" {
+			t.Errorf("got %s", r.URL)
+		}
+		io.WriteString(w, doc)
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	for body, want := range map[string]string{
+		`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-west-2</LocationConstraint>`: "us-west-2",
+		`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"/>`:                              "us-east-1",
+		`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">EU</LocationConstraint>`:        "eu-west-1",
+	} {
+		doc = body
+		got, err := s.GetBucketLocation(context.Background(), "bkt")
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v", body, got, err)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	b := newMockClient(t).Bucket("bkt")
+	ctx := context.Background()
+	for _, k := range []string{"logs/a", "logs/b", "other"} {
+		if err := b.Put(ctx, k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := b.Get(ctx, "logs/b"); err != nil || string(got) != "logs/b" {
+		t.Errorf("get: got %q, %v", got, err)
+	}
+	if err := b.Delete(ctx, "logs/a"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := b.List(ctx, "logs/")
+	if err != nil || len(l) != 1 || l[0].Key != "logs/b" {
+		t.Errorf("list: got %+v, %v", l, err)
+	}
+}
@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+)
+
+// values for PutOptions.ChecksumAlgorithm
+const (
+	ChecksumCRC32  = "CRC32"
+	ChecksumCRC32C = "CRC32C"
+	ChecksumSHA1   = "SHA1"
+	ChecksumSHA256 = "SHA256"
+)
+
+func newChecksum(alg string) (hash.Hash, error) {
+	switch alg {
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %q", alg)
+}
+
+// the header carrying a checksum by alg, e.g. x-amz-checksum-sha256
+func checksumHeader(alg string) string {
+	return http.CanonicalHeaderKey("x-amz-checksum-" + alg)
+}
+
+// computes the checksum o asks for over r, checks it against the one o
+// expects if any, and sets it in h for s3 to verify in turn
+func (o PutOptions) checksum(r io.Reader, h http.Header) error {
+	if o.ChecksumAlgorithm == "" {
+		return nil
+	}
+	d, err := newChecksum(o.ChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(d, r); err != nil {
+		return err
+	}
+	sum := base64.StdEncoding.EncodeToString(d.Sum(nil))
+	if o.Checksum != "" && o.Checksum != sum {
+		return fmt.Errorf("%s checksum of body is %s, not the expected %s", o.ChecksumAlgorithm, sum, o.Checksum)
+	}
+	h.Set(checksumHeader(o.ChecksumAlgorithm), sum)
+	return nil
+}
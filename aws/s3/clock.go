@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"errors"
+	"time"
+)
+
+// the time to date and sign a request with
+func (s SmartS3) now() time.Time {
+	t := s.clock()
+	if s.state != nil {
+		if d, ok := s.state.skews.Load(s.endpoint()); ok {
+			t = t.Add(d.(time.Duration))
+		}
+	}
+	return t
+}
+
+func (s SmartS3) clock() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// records the offset to s3's clock when err says ours is off, returning a
+// client that dates requests by s3's clock and whether a retry is now
+// worthwhile. only a client made by NewClient remembers the offset.
+func (s SmartS3) learnSkew(err error) (SmartS3, bool) {
+	var e *S3Error
+	if !errors.As(err, &e) || e.Code != "RequestTimeTooSkewed" {
+		return s, false
+	}
+	server, perr := time.Parse(time.RFC3339, e.ServerTime)
+	if perr != nil {
+		return s, false
+	}
+	// already corrected for, give or take the time since
+	if d := server.Sub(s.now()); -time.Second < d && d < time.Second {
+		return s, false
+	}
+	skew := server.Sub(s.clock())
+	if s.state != nil {
+		s.state.skews.Store(s.endpoint(), skew)
+		return s, true
+	}
+	clock := s.clock
+	s.Now = func() time.Time { return clock().Add(skew) }
+	return s, true
+}
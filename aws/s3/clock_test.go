@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// an endpoint whose clock reads server, refusing requests dated far from it;
+// the dates of the requests it gets are kept in dates
+func skewedServer(t *testing.T, server time.Time, dates *[]time.Time) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := time.Parse(time.RFC1123Z, r.Header.Get("Date"))
+		if err != nil {
+			t.Error(err)
+		}
+		*dates = append(*dates, d)
+		if skew := d.Sub(server); skew < -15*time.Minute || skew > 15*time.Minute {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "<Error><Code>RequestTimeTooSkewed</Code><ServerTime>%s</ServerTime></Error>", server.Format(time.RFC3339))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClockSkew(t *testing.T) {
+	server := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var dates []time.Time
+	srv := skewedServer(t, server, &dates)
+	s := NewClient(aws.Auth{AccessKey: "a", SecretKey: "b"})
+	s.Endpoint = srv.URL
+	s.Now = func() time.Time { return server.Add(-time.Hour) }
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dates) != 3 {
+		t.Fatalf("%d requests, want one refused and two dated by s3's clock", len(dates))
+	}
+	for i, d := range dates[1:] {
+		if !d.Equal(server) {
+			t.Errorf("request %d dated %v, want %v", i+2, d, server)
+		}
+	}
+
+	// another client of the same endpoint, with a good clock, is untouched
+	dates = nil
+	other := NewClient(aws.Auth{AccessKey: "a", SecretKey: "b"})
+	other.Endpoint = srv.URL
+	other.Now = func() time.Time { return server }
+	if _, err := other.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dates) != 1 || !dates[0].Equal(server) {
+		t.Errorf("dates %v, want just %v", dates, server)
+	}
+}
+
+func TestClockSkewUnremembered(t *testing.T) {
+	server := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var dates []time.Time
+	s := SmartS3{
+		Auth:     aws.Auth{AccessKey: "a", SecretKey: "b"},
+		Endpoint: skewedServer(t, server, &dates).URL,
+		Now:      func() time.Time { return server.Add(-time.Hour) },
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dates) != 4 || !dates[1].Equal(server) || !dates[3].Equal(server) {
+		t.Errorf("dates %v, want each request refused once and then dated by s3's clock", dates)
+	}
+}
@@ -0,0 +1,301 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// how a copy treats the source's metadata
+const (
+	MetadataCopy    = "COPY"
+	MetadataReplace = "REPLACE"
+)
+
+type CopyOptions struct {
+	// MetadataCopy (the default) or MetadataReplace
+	MetadataDirective string
+	// the destination's content type, user metadata and content headers when
+	// replacing metadata; otherwise they're the source's
+	ContentType        string
+	Metadata           map[string]string
+	ContentEncoding    string
+	CacheControl       string
+	ContentDisposition string
+	Expires            time.Time
+	// the destination's storage class and encryption, which s3 doesn't carry
+	// over from the source: STANDARD and the bucket's default when empty
+	StorageClass         string
+	ServerSideEncryption string
+	KMSKeyID             string
+	// copy only if the source's etag matches, or doesn't; otherwise the
+	// error wraps ErrPreconditionFailed
+	IfMatch, IfNoneMatch string
+	// also give the destination the source's tags, or its acl, which a copy
+	// otherwise leaves as the bucket's default. these take extra requests,
+	// and if one fails after the copy is made, the copy is deleted again.
+	CopyTags, CopyACL bool
+}
+
+type CopyResult struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// copies src to dst within s3, without the data passing through the client
+func (s SmartS3) Copy(ctx context.Context, src, dst Object, opts CopyOptions) (CopyResult, error) {
+	if err := checkObject(src); err != nil {
+		return CopyResult{}, err
+	}
+	if err := checkObject(dst); err != nil {
+		return CopyResult{}, err
+	}
+	// read what's to be carried over first, so that failing to leaves nothing behind
+	var tags map[string]string
+	var acl []byte
+	var err error
+	if opts.CopyTags {
+		if tags, err = s.GetObjectTagging(ctx, src); err != nil {
+			return CopyResult{}, err
+		}
+	}
+	if opts.CopyACL {
+		if acl, err = s.getObjectACL(ctx, src); err != nil {
+			return CopyResult{}, err
+		}
+	}
+	out, err := s.copyObject(ctx, src, dst, opts)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	if opts.CopyTags && len(tags) > 0 {
+		err = s.PutObjectTagging(ctx, dst, tags)
+	}
+	if opts.CopyACL && err == nil {
+		err = s.putObjectACL(ctx, dst, acl)
+	}
+	if err != nil && src != dst {
+		if derr := s.DeleteContext(context.Background(), DeleteRequest{Object: dst}); derr != nil {
+			return CopyResult{}, fmt.Errorf("%w (and deleting the copy failed: %v)", err, derr)
+		}
+	}
+	if err != nil {
+		return CopyResult{}, err
+	}
+	return out, nil
+}
+
+func (s SmartS3) copyObject(ctx context.Context, src, dst Object, opts CopyOptions) (CopyResult, error) {
+	h := http.Header{"X-Amz-Copy-Source": {copySource(src)}}
+	po := PutOptions{StorageClass: opts.StorageClass, ServerSideEncryption: opts.ServerSideEncryption, KMSKeyID: opts.KMSKeyID}
+	switch opts.MetadataDirective {
+	case "", MetadataCopy:
+	case MetadataReplace:
+		h.Set("X-Amz-Metadata-Directive", MetadataReplace)
+		ct := opts.ContentType
+		if ct == "" {
+			ct = mimeType(dst.Key)
+		}
+		h.Set("Content-Type", ct)
+		po.Metadata = opts.Metadata
+		po.ContentEncoding, po.CacheControl, po.ContentDisposition, po.Expires = opts.ContentEncoding, opts.CacheControl, opts.ContentDisposition, opts.Expires
+	default:
+		return CopyResult{}, fmt.Errorf("illegal metadata directive %q", opts.MetadataDirective)
+	}
+	if err := po.apply(h); err != nil {
+		return CopyResult{}, err
+	}
+	if opts.IfMatch != "" {
+		h.Set("X-Amz-Copy-Source-If-Match", opts.IfMatch)
+	}
+	if opts.IfNoneMatch != "" {
+		h.Set("X-Amz-Copy-Source-If-None-Match", opts.IfNoneMatch)
+	}
+	u, resource, err := s.createURL(dst)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		var out CopyResult
+		err := s.doXML(ctx, "PUT", u, resource, h, nil, &out)
+		return out, err
+	}
+	v, err := s.retry(ctx, dst.Bucket, fmt.Sprintf("copy %s to %s", print(src), print(dst)), f)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	return v.(CopyResult), nil
+}
+
+// gives o a fresh modification time, and contentType and metadata in place of
+// its own when they're not empty and nil, by copying it onto itself. its other
+// headers, storage class, encryption and, when it can be read, acl are kept. if o changes
+// meanwhile, the error wraps ErrPreconditionFailed.
+func Touch(auth aws.Auth, o Object, contentType string, metadata map[string]string) error {
+	_, err := NewClient(auth).Touch(context.Background(), o, contentType, metadata)
+	return err
+}
+
+func (s SmartS3) Touch(ctx context.Context, o Object, contentType string, metadata map[string]string) (CopyResult, error) {
+	info, err := s.HeadContext(ctx, HeadRequest{Object: o})
+	if err != nil {
+		return CopyResult{}, err
+	}
+	// the copy resets the acl to the bucket's default, so it's put back after,
+	// if we're allowed to read it
+	var e *S3Error
+	acl, err := s.getObjectACL(ctx, o)
+	if errors.As(err, &e) && e.Code == "AccessDenied" {
+		acl, err = nil, nil
+	}
+	if err != nil {
+		return CopyResult{}, err
+	}
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if metadata == nil {
+		metadata = info.Metadata
+	}
+	out, err := s.Copy(ctx, o, o, CopyOptions{
+		MetadataDirective:    MetadataReplace,
+		ContentType:          contentType,
+		Metadata:             metadata,
+		ContentEncoding:      info.ContentEncoding,
+		CacheControl:         info.CacheControl,
+		ContentDisposition:   info.ContentDisposition,
+		Expires:              info.Expires,
+		StorageClass:         info.StorageClass,
+		ServerSideEncryption: info.ServerSideEncryption,
+		KMSKeyID:             info.KMSKeyID,
+		IfMatch:              info.ETag,
+	})
+	if err != nil {
+		return CopyResult{}, err
+	}
+	if acl == nil {
+		return out, nil
+	}
+	// a bucket with acls disabled has none to restore
+	if err := s.putObjectACL(ctx, o, acl); err != nil && !(errors.As(err, &e) && e.Code == "AccessControlListNotSupported") {
+		return out, fmt.Errorf("touched %s, but couldn't restore its acl: %w", print(o), err)
+	}
+	return out, nil
+}
+
+// reported by Move when the copy was made but the source couldn't be deleted
+var ErrSourceNotDeleted = errors.New("source not deleted")
+
+// renames src to dst by copying it within s3 and then deleting src. if the
+// delete fails, dst is left in place and the error wraps ErrSourceNotDeleted.
+func (s SmartS3) Move(ctx context.Context, src, dst Object) error {
+	if _, err := s.Copy(ctx, src, dst, CopyOptions{}); err != nil {
+		return err
+	}
+	if err := s.DeleteContext(ctx, DeleteRequest{Object: src}); err != nil {
+		return fmt.Errorf("%w: copied %s to %s, but %w", ErrSourceNotDeleted, print(src), print(dst), err)
+	}
+	return nil
+}
+
+// the x-amz-copy-source value for o: "/bucket/key", with the key percent-encoded
+func copySource(o Object) string {
+	return "/" + o.Bucket + "/" + escapePath(o.Key)
+}
+
+// most s3 copies in one request, beyond which CopyLarge is needed
+const MaxCopySize = 5 << 30
+
+type copyPartResult struct {
+	ETag string
+}
+
+// makes part partNumber of m a server-side copy of src, or of just the bytes
+// in r when it's not nil
+func (s SmartS3) UploadPartCopy(ctx context.Context, m MultipartUpload, partNumber int, src Object, r *ByteRange) (CompletedPart, error) {
+	if partNumber < 1 || partNumber > 10000 {
+		return CompletedPart{}, fmt.Errorf("part number %d out of range 1-10000", partNumber)
+	}
+	if err := checkObject(src); err != nil {
+		return CompletedPart{}, err
+	}
+	q := url.Values{"partNumber": {fmt.Sprint(partNumber)}, "uploadId": {m.UploadID}}
+	u, resource, err := s.subresourceURL(m.Object, q)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+	h := http.Header{"X-Amz-Copy-Source": {copySource(src)}}
+	if r != nil {
+		h.Set("X-Amz-Copy-Source-Range", r.header())
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		var out copyPartResult
+		if err := s.doXML(ctx, "PUT", u, resource, h, nil, &out); err != nil {
+			return nil, err
+		}
+		return CompletedPart{PartNumber: partNumber, ETag: out.ETag}, nil
+	}
+	v, err := s.retry(ctx, m.Object.Bucket, fmt.Sprintf("copy part %d of %s from %s", partNumber, print(m), print(src)), f)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+	return v.(CompletedPart), nil
+}
+
+// copies src to dst within s3 whatever its size: with Copy when it fits in
+// a part, and otherwise in ranged parts copied concurrently. parts are grown
+// beyond opts.PartSize as needed to stay within s3's 10000. as with any
+// multipart upload, dst doesn't inherit src's user metadata.
+func (s SmartS3) CopyLarge(ctx context.Context, src, dst Object, opts UploadOptions) (PutResult, error) {
+	info, err := s.HeadContext(ctx, HeadRequest{Object: src})
+	if err != nil {
+		return PutResult{}, err
+	}
+	partSize, concurrency := opts.sizes()
+	if min := (info.ContentLength + 9999) / 10000; partSize < min {
+		partSize = min
+	}
+	if partSize > MaxCopySize {
+		partSize = MaxCopySize
+	}
+	if info.ContentLength <= partSize {
+		res, err := s.Copy(ctx, src, dst, CopyOptions{})
+		return PutResult{ETag: res.ETag}, err
+	}
+	ct := opts.ContentType
+	if ct == "" {
+		ct = info.ContentType
+	}
+	m, err := s.InitiateMultipartUpload(ctx, dst, ct)
+	if err != nil {
+		return PutResult{}, err
+	}
+	count := int((info.ContentLength + partSize - 1) / partSize)
+	parts := make([]CompletedPart, count)
+	p := newPool(ctx, concurrency)
+	for i := 0; i < count; i++ {
+		i := i
+		r := ByteRange{Start: int64(i) * partSize, End: int64(i+1)*partSize - 1}
+		if r.End >= info.ContentLength {
+			r.End = info.ContentLength - 1
+		}
+		p.Go(func(ctx context.Context) error {
+			part, err := s.UploadPartCopy(ctx, m, i+1, src, &r)
+			parts[i] = part
+			return err
+		})
+	}
+	if err := p.Wait(); err != nil {
+		// abort with a fresh context, since ours may be what was cancelled
+		if aerr := s.AbortMultipartUpload(context.Background(), m); aerr != nil {
+			return PutResult{}, fmt.Errorf("%w (and abort failed: %v)", err, aerr)
+		}
+		return PutResult{}, err
+	}
+	return s.CompleteMultipartUpload(ctx, m, parts)
+}
@@ -0,0 +1,316 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+	"github.com/xoba/goutil/aws/s3/mock"
+)
+
+const testACL = `<AccessControlPolicy><Owner><ID>o</ID></Owner><AccessControlList><Grant><Grantee><URI>http://acs.amazonaws.com/groups/global/AllUsers</URI></Grantee><Permission>READ</Permission></Grant></AccessControlList></AccessControlPolicy>`
+
+// records each request, serving a fixed object, tag set and acl
+type copyServer struct {
+	mu      sync.Mutex
+	reqs    []*http.Request
+	bodies  []string
+	putACL  int
+	aclCode string
+	// when set, reading the acl, or putting tags, fails with it
+	getACLCode, taggingCode string
+}
+
+func (c *copyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reqs = append(c.reqs, r)
+	c.bodies = append(c.bodies, string(b))
+	q := r.URL.Query()
+	switch {
+	case r.Method == "HEAD":
+		w.Header().Set("ETag", `"e1"`)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("X-Amz-Storage-Class", "STANDARD_IA")
+		w.Header().Set("X-Amz-Meta-Color", "red")
+	case r.Method == "GET" && q.Has("acl") && c.getACLCode != "":
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", c.getACLCode)
+	case r.Method == "GET" && q.Has("acl"):
+		fmt.Fprint(w, testACL)
+	case r.Method == "GET" && q.Has("tagging"):
+		fmt.Fprint(w, "<Tagging><TagSet><Tag><Key>k</Key><Value>v</Value></Tag></TagSet></Tagging>")
+	case r.Method == "PUT" && q.Has("tagging"):
+		if c.taggingCode != "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", c.taggingCode)
+		}
+	case r.Method == "PUT" && q.Has("acl"):
+		c.putACL++
+		if c.aclCode != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", c.aclCode)
+		}
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		fmt.Fprint(w, `<CopyObjectResult><ETag>"e2"</ETag><LastModified>2020-01-01T00:00:00.000Z</LastModified></CopyObjectResult>`)
+	}
+}
+
+// the first request made with the given method and, unless empty, query
+func (c *copyServer) find(method, query string) (*http.Request, string) {
+	for i, r := range c.reqs {
+		if r.Method == method && (query == "" || r.URL.Query().Has(query)) {
+			return r, c.bodies[i]
+		}
+	}
+	return nil, ""
+}
+
+func TestTouch(t *testing.T) {
+	c := &copyServer{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, PathStyle: true}
+	if _, err := s.Touch(context.Background(), Object{"bkt", "dir/a b"}, "text/html", nil); err != nil {
+		t.Fatal(err)
+	}
+	var cp *http.Request
+	for _, r := range c.reqs {
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			cp = r
+		}
+	}
+	if cp == nil {
+		t.Fatal("no copy")
+	}
+	for k, want := range map[string]string{
+		"X-Amz-Copy-Source":          "/bkt/dir/a%20b",
+		"X-Amz-Metadata-Directive":   "REPLACE",
+		"Content-Type":               "text/html",
+		"Cache-Control":              "max-age=60",
+		"X-Amz-Storage-Class":        "STANDARD_IA",
+		"X-Amz-Meta-Color":           "red",
+		"X-Amz-Copy-Source-If-Match": `"e1"`,
+	} {
+		if got := cp.Header.Get(k); got != want {
+			t.Errorf("%s: got %q, want %q", k, got, want)
+		}
+	}
+	if _, body := c.find("PUT", "acl"); body != testACL {
+		t.Errorf("acl put back as %q", body)
+	}
+}
+
+func TestTouchACLsDisabled(t *testing.T) {
+	c := &copyServer{aclCode: "AccessControlListNotSupported"}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, PathStyle: true}
+	if _, err := s.Touch(context.Background(), Object{"bkt", "k"}, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.aclCode = "AccessDenied"
+	if _, err := s.Touch(context.Background(), Object{"bkt", "k"}, "", nil); err == nil {
+		t.Fatal("a failed acl restore went unreported")
+	}
+}
+
+func TestCopyTagsAndACL(t *testing.T) {
+	for _, c := range []*copyServer{{}, {taggingCode: "AccessDenied"}, {aclCode: "AccessDenied"}} {
+		srv := httptest.NewServer(c)
+		defer srv.Close()
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+		_, err := s.Copy(context.Background(), Object{"bkt", "src"}, Object{"bkt", "dst"}, CopyOptions{CopyTags: true, CopyACL: true})
+		failed := c.taggingCode != "" || c.aclCode != ""
+		if (err != nil) != failed {
+			t.Errorf("%+v: got %v", c, err)
+		}
+		if _, body := c.find("PUT", "tagging"); !strings.Contains(body, "<Key>k</Key><Value>v</Value>") {
+			t.Errorf("%+v: tags put as %q", c, body)
+		}
+		if _, body := c.find("PUT", "acl"); c.taggingCode == "" && body != testACL {
+			t.Errorf("%+v: acl put as %q", c, body)
+		}
+		del, _ := c.find("DELETE", "")
+		if failed && (del == nil || del.URL.Path != "/bkt/dst") {
+			t.Errorf("%+v: the copy wasn't deleted", c)
+		}
+		if !failed && del != nil {
+			t.Errorf("%+v: a good copy was deleted", c)
+		}
+	}
+}
+
+func TestTouchACLUnreadable(t *testing.T) {
+	c := &copyServer{getACLCode: "AccessDenied"}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, PathStyle: true}
+	if _, err := s.Touch(context.Background(), Object{"bkt", "k"}, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := c.find("PUT", ""); r == nil || r.Header.Get("X-Amz-Copy-Source") == "" {
+		t.Error("no copy")
+	}
+	if c.putACL != 0 {
+		t.Errorf("an acl that couldn't be read was put %d times", c.putACL)
+	}
+}
+
+// a client of a mock s3 holding "bkt", whose requests are refused with
+// AccessDenied when refuse says so
+func newRefusingClient(t *testing.T, refuse func(*http.Request) bool) (SmartS3, *mock.Server) {
+	m := mock.NewServer("bkt")
+	t.Cleanup(m.Close)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if refuse(r) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code></Error>")
+			return
+		}
+		m.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}, m
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	src, dst := Object{"bkt", "from"}, Object{"bkt", "to"}
+	for _, c := range []struct {
+		name     string
+		refuse   func(*http.Request) bool
+		srcKept  bool
+		dstMade  bool
+		notMoved bool
+	}{
+		{"moved", func(*http.Request) bool { return false }, false, true, false},
+		{"copy refused", func(r *http.Request) bool { return r.Header.Get("X-Amz-Copy-Source") != "" }, true, false, false},
+		{"delete refused", func(r *http.Request) bool { return r.Method == "DELETE" }, true, true, true},
+	} {
+		s, m := newRefusingClient(t, c.refuse)
+		if err := s.PutObjectContext(ctx, PutObjectRequest{Object: src, Data: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+		err := s.Move(ctx, src, dst)
+		if (err == nil) != (c.dstMade && !c.notMoved) || errors.Is(err, ErrSourceNotDeleted) != c.notMoved {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+		if _, ok := m.Object(src.Bucket, src.Key); ok != c.srcKept {
+			t.Errorf("%s: source kept %v", c.name, ok)
+		}
+		if b, ok := m.Object(dst.Bucket, dst.Key); ok != c.dstMade || ok && string(b) != "x" {
+			t.Errorf("%s: destination %q, %v", c.name, b, ok)
+		}
+	}
+}
+
+func TestCopySourceEscaped(t *testing.T) {
+	c := &copyServer{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	for key, want := range map[string]string{
+		"a b":                "/bkt/a%20b",
+		"1+1=2":              "/bkt/1%2B1%3D2",
+		"dir/naïve café.txt": "/bkt/dir/na%C3%AFve%20caf%C3%A9.txt",
+		"?#%&":               "/bkt/%3F%23%25%26",
+	} {
+		c.reqs = nil
+		if _, err := s.Copy(context.Background(), Object{"bkt", key}, Object{"bkt", "dst"}, CopyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.reqs[0].Header.Get("X-Amz-Copy-Source"); got != want {
+			t.Errorf("%q: got %q, want %q", key, got, want)
+		}
+	}
+}
+
+// a source object of size bytes to copy in parts, recording the range each
+// part is copied from, and refusing part failPart when it's not zero
+type partCopyServer struct {
+	mu        sync.Mutex
+	size      int64
+	failPart  int
+	ranges    map[int]string
+	completed string
+	aborts    int
+}
+
+func (c *partCopyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q := r.URL.Query()
+	switch {
+	case r.Method == "HEAD":
+		w.Header().Set("Content-Length", fmt.Sprint(c.size))
+	case r.Method == "POST" && q.Has("uploads"):
+		c.ranges = make(map[int]string)
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == "PUT" && q.Has("partNumber"):
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if n == c.failPart {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code></Error>")
+			return
+		}
+		c.ranges[n] = r.Header.Get("X-Amz-Copy-Source") + " " + r.Header.Get("X-Amz-Copy-Source-Range")
+		fmt.Fprintf(w, `<CopyPartResult><ETag>"p%d"</ETag></CopyPartResult>`, n)
+	case r.Method == "POST":
+		c.completed = string(b)
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == "DELETE":
+		c.aborts++
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestCopyLarge(t *testing.T) {
+	c := &partCopyServer{size: 2*MinPartSize + 5}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	opts := UploadOptions{PartSize: MinPartSize, Concurrency: 2}
+	res, err := s.CopyLarge(context.Background(), Object{"bkt", "src"}, Object{"bkt", "dst"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{
+		1: "/bkt/src bytes=0-5242879",
+		2: "/bkt/src bytes=5242880-10485759",
+		3: "/bkt/src bytes=10485760-10485764",
+	}
+	if fmt.Sprint(c.ranges) != fmt.Sprint(want) {
+		t.Errorf("parts copied from %v, want %v", c.ranges, want)
+	}
+	for n := 1; n <= 3; n++ {
+		if part := fmt.Sprintf(`<PartNumber>%d</PartNumber><ETag>&#34;p%d&#34;</ETag>`, n, n); !strings.Contains(c.completed, part) {
+			t.Errorf("completed with %s, missing part %d", c.completed, n)
+		}
+	}
+	if res.ETag != `"done"` || c.aborts != 0 {
+		t.Errorf("etag %q, %d aborts", res.ETag, c.aborts)
+	}
+
+	c = &partCopyServer{size: 2*MinPartSize + 5, failPart: 2}
+	srv = httptest.NewServer(c)
+	defer srv.Close()
+	s.Endpoint = srv.URL
+	if _, err := s.CopyLarge(context.Background(), Object{"bkt", "src"}, Object{"bkt", "dst"}, opts); err == nil {
+		t.Fatal("a copy with a refused part succeeded")
+	}
+	if c.aborts != 1 || c.completed != "" {
+		t.Errorf("%d aborts, completed with %q", c.aborts, c.completed)
+	}
+}
@@ -2,34 +2,149 @@ package s3
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"github.com/xoba/goutil"
 	"github.com/xoba/goutil/aws"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	N = "\n"
+	N               = "\n"
+	DefaultEndpoint = "s3.amazonaws.com"
+	metaPrefix      = "x-amz-meta-"
+	DefaultTimeout  = 60 * time.Second
+	// sent as the User-Agent of every request, followed by SmartS3.UserAgent
+	DefaultUserAgent = "goutil-s3/1.0"
 )
 
+var (
+	ErrNotFound = errors.New("not found")
+	// a conditional get found the object unchanged
+	ErrNotModified = errors.New("not modified")
+	// an If-Match or If-None-Match condition on a delete or copy didn't hold
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// an object was bigger than GetRequest.MaxBytes allows
+	ErrTooLarge = errors.New("object too large")
+	// GetRange was answered with the whole object rather than the range
+	ErrRangeIgnored = errors.New("range ignored")
+)
+
+// content types by lowercased extension, consulted before the system's
+var mimeTypes = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// makes keys ending in ext, e.g. ".wasm", default to contentType wherever it's
+// guessed, whatever the system's mime database says
+func RegisterMIME(ext, contentType string) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	mimeTypes.Lock()
+	defer mimeTypes.Unlock()
+	mimeTypes.m[strings.ToLower(ext)] = contentType
+}
+
+// the content type for a key, by its extension; application/octet-stream when unknown
 func mimeType(name string) string {
+	if t := typeByExtension(name); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// the type registered for name's extension, or "" if none is
+func typeByExtension(name string) string {
 	ext := filepath.Ext(name)
+	mimeTypes.RLock()
+	t, ok := mimeTypes.m[strings.ToLower(ext)]
+	mimeTypes.RUnlock()
+	if ok {
+		return t
+	}
 	return mime.TypeByExtension(ext)
 }
 
-func list(auth aws.Auth, req ListRequest) (out ListBucketResult, err error) {
-	if req.Bucket == "" {
-		return out, errors.New("no bucket name")
+// how much of a body http.DetectContentType looks at
+const sniffLen = 512
+
+// the type for name by its extension, or failing that, as sniffed from the
+// first sniffLen bytes of its content
+func sniffType(name string, head []byte) string {
+	if t := typeByExtension(name); t != "" {
+		return t
+	}
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	return http.DetectContentType(head)
+}
+
+// the first sniffLen bytes from a fresh reader of f
+func sniffHead(f goutil.ReaderFactory) ([]byte, error) {
+	r, err := f.CreateReader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, sniffLen))
+}
+
+func (s SmartS3) list(ctx context.Context, req ListRequest) (out ListBucketResult, err error) {
+	hreq, err := s.listRequest(ctx, req)
+	if err != nil {
+		return
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("list", start, hreq, resp, err) }()
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return out, responseError(resp)
+	}
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, resp.Body)
+	if err != nil {
+		return
+	}
+	if err = xml.Unmarshal(buf.Bytes(), &out); err != nil {
+		return out, fmt.Errorf("can't parse listing of %s: %w (body starts %q)", req.Bucket, err, snippet(buf.Bytes()))
+	}
+	if req.EncodingType == "url" {
+		if err = out.urlDecode(); err != nil {
+			return out, fmt.Errorf("can't decode listing of %s: %w", req.Bucket, err)
+		}
+	}
+	return
+}
+
+// the signed request for one page of a listing, ready to send
+func (s SmartS3) listRequest(ctx context.Context, req ListRequest) (*http.Request, error) {
+	if err := ValidateBucketName(req.Bucket); err != nil {
+		return nil, err
 	}
 	query := make(url.Values)
 	if req.MaxKeys > 0 {
@@ -43,226 +158,1031 @@ func list(auth aws.Auth, req ListRequest) (out ListBucketResult, err error) {
 	if req.Prefix != "" {
 		query.Add("prefix", req.Prefix)
 	}
-	u, err := url.Parse("https://s3.amazonaws.com/" + req.Bucket + "/?" + query.Encode())
-	now := time.Now()
-	sig, err := signList(u.Path, auth, now)
+	if req.Delimiter != "" {
+		query.Add("delimiter", req.Delimiter)
+	}
+	if req.EncodingType != "" {
+		query.Add("encoding-type", req.EncodingType)
+	}
+	u, err := url.Parse(s.endpointFor(req.Bucket) + "/" + req.Bucket + "/?" + query.Encode())
 	if err != nil {
-		return
+		return nil, fmt.Errorf("can't list %q: %w", req.Bucket, err)
 	}
-	transport := http.DefaultTransport
-	hreq, err := http.NewRequest("GET", u.String(), nil)
+	resource := s.hostStyle(u, req.Bucket)
+	now := s.now()
+	hreq, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
-		return
+		return nil, err
 	}
 	hreq.Header.Add("Date", format(now))
-	hreq.Header.Add("Authorization", "AWS "+auth.AccessKey+":"+sig)
-	resp, err := transport.RoundTrip(hreq)
+	if err = s.authorize(hreq, resource, emptyPayload, now); err != nil {
+		return nil, err
+	}
+	return hreq, nil
+}
+
+// undoes encoding-type=url on every key-like field of a listing
+func (r *ListBucketResult) urlDecode() (err error) {
+	dec := func(s *string) {
+		if err == nil {
+			*s, err = url.QueryUnescape(*s)
+		}
+	}
+	dec(&r.Prefix)
+	dec(&r.Marker)
+	dec(&r.NextMarker)
+	dec(&r.Delimiter)
+	for i := range r.Contents {
+		dec(&r.Contents[i].Key)
+	}
+	for i := range r.CommonPrefixes {
+		dec(&r.CommonPrefixes[i])
+	}
+	return err
+}
+
+// the start of a response body, for error messages
+func snippet(b []byte) string {
+	if len(b) > 200 {
+		b = b[:200]
+	}
+	return string(b)
+}
+
+// base url of the service, without a trailing slash
+func (s SmartS3) endpoint() string {
+	e := s.Endpoint
+	switch {
+	case e != "":
+	case s.UseFIPS || s.UseDualStack:
+		return s.regionalEndpoint(s.Auth.GetRegion())
+	default:
+		e = DefaultEndpoint
+	}
+	if !strings.Contains(e, "://") {
+		e = "https://" + e
+	}
+	return strings.TrimSuffix(e, "/")
+}
+
+// the aws endpoint for region, in the fips and dualstack variants if asked for
+func (s SmartS3) regionalEndpoint(region string) string {
+	host := "s3"
+	if s.UseFIPS {
+		host += "-fips"
+	}
+	if s.UseDualStack {
+		host += ".dualstack"
+	}
+	return "https://" + host + "." + region + ".amazonaws.com"
+}
+
+// sends a signed request, retrying transient failures according to s.Retry
+func (s SmartS3) send(hreq *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := s.sendOnce(hreq)
+		if attempt >= s.Retry.attempts() || !s.Retry.retryable(hreq, resp, err) {
+			return resp, err
+		}
+		wait := s.Retry.backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleep(hreq.Context(), wait); err != nil {
+			return nil, err
+		}
+		if hreq, err = rewind(hreq); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// one attempt at a request, bounded by s.Timeout until its body is closed.
+// redirects are returned rather than followed, since following one would need a fresh signature.
+func (s SmartS3) sendOnce(hreq *http.Request) (*http.Response, error) {
+	c := http.DefaultClient
+	if s.HTTPClient != nil {
+		c = s.HTTPClient
+	}
+	nofollow := *c
+	nofollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	// each attempt gets its own copy of the signed request to adjust, so a
+	// retry doesn't see what the modifier did to the attempt before
+	hreq = hreq.Clone(hreq.Context())
+	// set after signing so that proxies rewriting it don't break the signature
+	if s.UserAgent != "" {
+		hreq.Header.Set("User-Agent", DefaultUserAgent+" "+s.UserAgent)
+	} else {
+		hreq.Header.Set("User-Agent", DefaultUserAgent)
+	}
+	if s.RequestModifier != nil {
+		s.RequestModifier(hreq)
+	}
+	t := s.Timeout
+	if t == 0 {
+		t = DefaultTimeout
+	}
+	if t < 0 {
+		resp, err := nofollow.Do(hreq)
+		s.traceResponse(hreq, resp, err)
+		return resp, err
+	}
+	ctx, cancel := context.WithTimeout(hreq.Context(), t)
+	resp, err := nofollow.Do(hreq.WithContext(ctx))
+	s.traceResponse(hreq, resp, err)
 	if err != nil {
-		return
+		cancel()
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return out, errors.New(resp.Status)
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// releases a request's timeout once its body is done with
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// signs and sends a request, returning the response only if it succeeded.
+// resource is the path v2 signs, including any subresource such as "?uploads".
+func (s SmartS3) do(ctx context.Context, method string, u *url.URL, resource string, header http.Header, body []byte) (*http.Response, error) {
+	u, err := s.relocate(u, resource)
+	if err != nil {
+		return nil, err
 	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
+	now := s.now()
+	hreq, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
 	if err != nil {
-		return
+		return nil, err
 	}
-	xml.Unmarshal(buf.Bytes(), &out)
-	return
+	for k, vs := range header {
+		for _, v := range vs {
+			hreq.Header.Add(k, v)
+		}
+	}
+	hreq.Header.Set("Date", format(now))
+	hreq.ContentLength = int64(len(body))
+	if s.BytesPerSecond > 0 && len(body) > 0 {
+		hreq.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(s.throttle(bytes.NewReader(body))), nil
+		}
+		hreq.Body, _ = hreq.GetBody()
+	}
+	if err := s.authorize(hreq, resource, payloadHash(body), now); err != nil {
+		return nil, err
+	}
+	resp, err := s.send(hreq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
 }
 
-func createURL(o Object) (*url.URL, error) {
-	return url.Parse("https://s3.amazonaws.com/" + esc(o.Bucket) + "/" + esc(o.Key))
+// like do, but decodes an xml response into out
+func (s SmartS3) doXML(ctx context.Context, method string, u *url.URL, resource string, header http.Header, body []byte, out interface{}) error {
+	resp, err := s.do(ctx, method, u, resource, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// some operations report failure in the body of a 200
+	var e struct {
+		XMLName xml.Name `xml:"Error"`
+		S3Error
+	}
+	if xml.Unmarshal(buf, &e) == nil {
+		e.HTTPStatusCode, e.Status = resp.StatusCode, resp.Status
+		return &e.S3Error
+	}
+	return xml.Unmarshal(buf, out)
 }
 
-func get(auth aws.Auth, req GetRequest) (io.ReadCloser, error) {
-	u, err := createURL(req.Object)
+// u, made before a redirect said where its bucket lives, pointed there instead.
+// resource is what v2 signs for u, and so names the bucket and key.
+func (s SmartS3) relocate(u *url.URL, resource string) (*url.URL, error) {
+	bucket := bucketOf(resource)
+	e, err := url.Parse(s.endpointFor(bucket))
 	if err != nil {
 		return nil, err
 	}
-	now := time.Now()
-	sig, err := signGet(u.Path, auth, now)
+	if u.Host == e.Host || bucket != "" && u.Host == bucket+"."+e.Host {
+		return u, nil
+	}
+	path := resource
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	v, err := url.Parse(e.String() + path)
 	if err != nil {
 		return nil, err
 	}
-	transport := http.DefaultTransport
-	hreq, err := http.NewRequest("GET", u.String(), nil)
+	v.RawPath, v.RawQuery = path, u.RawQuery
+	if bucket != "" {
+		s.hostStyle(v, bucket)
+	}
+	return v, nil
+}
+
+// the url of o and the resource v2 signs for it, which is always the path-style one
+func (s SmartS3) createURL(o Object) (*url.URL, string, error) {
+	// encode the path once, and use exactly that for both the request and its signature
+	path := "/" + uriEncode(o.Bucket) + "/" + escapePath(o.Key)
+	u, err := url.Parse(s.endpointFor(o.Bucket) + path)
+	if err != nil {
+		return nil, "", err
+	}
+	u.RawPath = path
+	return u, s.hostStyle(u, o.Bucket), nil
+}
+
+// moves the bucket from a path-style u into its host, unless PathStyle is set or the
+// bucket can't be a hostname; returns the path-style path, which is what v2 signs
+func (s SmartS3) hostStyle(u *url.URL, bucket string) string {
+	resource := u.EscapedPath()
+	if s.PathStyle || !dnsCompatible(bucket) || net.ParseIP(u.Hostname()) != nil {
+		return resource
+	}
+	u.Host = bucket + "." + u.Host
+	u.Path = strings.TrimPrefix(u.Path, "/"+bucket)
+	u.RawPath = strings.TrimPrefix(u.RawPath, "/"+bucket)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return resource
+}
+
+// whether a bucket name works as a single dns label under tls: lowercase letters,
+// digits and hyphens, and no dots since they'd break certificate matching
+func dnsCompatible(bucket string) bool {
+	if len(bucket) < 3 || len(bucket) > 63 || bucket[0] == '-' || bucket[len(bucket)-1] == '-' {
+		return false
+	}
+	for _, c := range bucket {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// the url of o with a query of subresources, and the resource v2 signs for it
+func (s SmartS3) subresourceURL(o Object, q url.Values) (*url.URL, string, error) {
+	u, resource, err := s.createURL(o)
+	if err != nil {
+		return nil, "", err
+	}
+	u.RawQuery = joinQuery(q, url.QueryEscape)
+	signed := make(url.Values)
+	for k, v := range q {
+		if subresources[k] {
+			signed[k] = v
+		}
+	}
+	if len(signed) > 0 {
+		resource += "?" + joinQuery(signed, func(s string) string { return s })
+	}
+	return u, resource, nil
+}
+
+// the url of o, or of one version of it when versionID isn't empty
+func (s SmartS3) versionURL(o Object, versionID string) (*url.URL, string, error) {
+	if versionID == "" {
+		return s.createURL(o)
+	}
+	return s.subresourceURL(o, url.Values{"versionId": {versionID}})
+}
+
+// the query parameters v2 signs as part of the resource; others are left out
+var subresources = map[string]bool{
+	"acl": true, "cors": true, "delete": true, "encryption": true, "legal-hold": true,
+	"lifecycle": true, "location": true, "logging": true, "notification": true,
+	"object-lock": true, "partNumber": true, "policy": true, "replication": true,
+	"requestPayment": true, "restore": true, "retention": true, "select": true,
+	"select-type": true, "tagging": true, "torrent": true, "uploadId": true,
+	"uploads": true, "versionId": true, "versioning": true, "versions": true,
+	"website":                true,
+	"response-cache-control": true, "response-content-disposition": true,
+	"response-content-encoding": true, "response-content-language": true,
+	"response-content-type": true, "response-expires": true,
+}
+
+// sorted query parameters, with valueless ones appearing bare as s3 writes them (e.g. "?uploads")
+func joinQuery(q url.Values, escape func(string) string) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var a []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			if v == "" {
+				a = append(a, escape(k))
+			} else {
+				a = append(a, escape(k)+"="+escape(v))
+			}
+		}
+	}
+	return strings.Join(a, "&")
+}
+
+// the signed request for a get, ready to send
+func (s SmartS3) getRequest(ctx context.Context, req GetRequest) (*http.Request, error) {
+	var ranges string
+	if req.Range != nil {
+		ranges = req.Range.header()
+	}
+	return s.rangesRequest(ctx, req, ranges)
+}
+
+// a get for the given Range header, or the whole object when it's empty
+func (s SmartS3) rangesRequest(ctx context.Context, req GetRequest, ranges string) (*http.Request, error) {
+	u, resource, err := s.versionURL(req.Object, req.VersionID)
 	if err != nil {
 		return nil, err
 	}
-	hreq.Header.Add("Date", format(now))
-	hreq.Header.Add("Authorization", "AWS "+auth.AccessKey+":"+sig)
-	resp, err := transport.RoundTrip(hreq)
+	now := s.now()
+	hreq, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New(resp.Status)
+	hreq.Header.Add("Date", format(now))
+	if ranges != "" {
+		hreq.Header.Add("Range", ranges)
+	}
+	if !req.IfModifiedSince.IsZero() {
+		hreq.Header.Add("If-Modified-Since", req.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
+	if req.IfNoneMatch != "" {
+		hreq.Header.Add("If-None-Match", req.IfNoneMatch)
+	}
+	// keeps net/http from quietly gunzipping, which would leave the body
+	// disagreeing with the etag and content length
+	hreq.Header.Add("Accept-Encoding", "identity")
+	if err := s.authorize(hreq, resource, emptyPayload, now); err != nil {
+		return nil, err
 	}
-	return resp.Body, nil
+	return hreq, nil
 }
 
-func del(auth aws.Auth, req DeleteRequest) (err error) {
-	u, err := createURL(req.Object)
+func (s SmartS3) get(ctx context.Context, req GetRequest) (out GetResult, err error) {
+	hreq, err := s.getRequest(ctx, req)
 	if err != nil {
-		return err
+		return
 	}
-	now := time.Now()
-	sig, err := signDelete(u.Path, auth, now)
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("get", start, hreq, resp, err) }()
 	if err != nil {
 		return
 	}
-	transport := http.DefaultTransport
-	hreq, err := http.NewRequest("DELETE", u.String(), nil)
+	if resp.StatusCode == 304 {
+		resp.Body.Close()
+		return out, ErrNotModified
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 206 {
+		defer resp.Body.Close()
+		return out, responseError(resp)
+	}
+	out.Body = resp.Body
+	if s.BytesPerSecond > 0 {
+		out.Body = struct {
+			io.Reader
+			io.Closer
+		}{s.throttle(resp.Body), resp.Body}
+	}
+	if req.Decompress && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		z, err := gzip.NewReader(out.Body)
+		if err != nil {
+			resp.Body.Close()
+			return out, fmt.Errorf("can't gunzip %v: %w", req.Object, err)
+		}
+		out.Body = gunzipBody{z, resp.Body}
+	}
+	info := objectInfo(resp.Header)
+	out.ContentLength = resp.ContentLength
+	out.ContentType, out.ETag, out.LastModified = info.ContentType, info.ETag, info.LastModified
+	out.Metadata = info.Metadata
+	out.CacheControl, out.ContentDisposition, out.Expires = info.CacheControl, info.ContentDisposition, info.Expires
+	out.ContentRange = resp.Header.Get("Content-Range")
+	out.TotalSize = totalSize(resp)
+	return out, nil
+}
+
+func (r ByteRange) header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
+// size of the whole object, from Content-Range for a partial response; -1 if unknown
+func totalSize(resp *http.Response) int64 {
+	if resp.StatusCode != 206 {
+		return resp.ContentLength
+	}
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndex(cr, "/")
+	if i < 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(cr[i+1:], 10, 64)
 	if err != nil {
-		return err
+		return -1
+	}
+	return n
+}
+
+func (s SmartS3) head(ctx context.Context, req HeadRequest) (out ObjectInfo, err error) {
+	u, resource, err := s.createURL(req.Object)
+	if err != nil {
+		return
+	}
+	now := s.now()
+	hreq, err := http.NewRequestWithContext(ctx, "HEAD", u.String(), nil)
+	if err != nil {
+		return
 	}
 	hreq.Header.Add("Date", format(now))
-	hreq.Header.Add("Authorization", "AWS "+auth.AccessKey+":"+sig)
-	resp, err := transport.RoundTrip(hreq)
+	if req.Checksums {
+		hreq.Header.Add("X-Amz-Checksum-Mode", "ENABLED")
+	}
+	if err = s.authorize(hreq, resource, emptyPayload, now); err != nil {
+		return
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("head", start, hreq, resp, err) }()
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return out, fmt.Errorf("%w: %s/%s", ErrNotFound, req.Object.Bucket, req.Object.Key)
+	}
+	if resp.StatusCode != 200 {
+		return out, responseError(resp)
+	}
+	return objectInfo(resp.Header), nil
+}
+
+// parses the object attributes s3 returns as response headers
+func objectInfo(h http.Header) (out ObjectInfo) {
+	out.ContentLength, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	out.ETag = h.Get("ETag")
+	out.ContentType = h.Get("Content-Type")
+	out.IDs = requestIDs(h)
+	out.ContentEncoding = h.Get("Content-Encoding")
+	out.CacheControl = h.Get("Cache-Control")
+	out.ContentDisposition = h.Get("Content-Disposition")
+	out.Expires, _ = http.ParseTime(h.Get("Expires"))
+	out.LastModified, _ = http.ParseTime(h.Get("Last-Modified"))
+	out.ServerSideEncryption = h.Get("X-Amz-Server-Side-Encryption")
+	out.KMSKeyID = h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")
+	out.StorageClass = h.Get("X-Amz-Storage-Class")
+	out.WebsiteRedirectLocation = h.Get("X-Amz-Website-Redirect-Location")
+	out.ObjectLockMode = h.Get("X-Amz-Object-Lock-Mode")
+	out.ObjectLockRetainUntil, _ = time.Parse(time.RFC3339, h.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	out.ObjectLockLegalHold = h.Get("X-Amz-Object-Lock-Legal-Hold") == "ON"
+	out.ChecksumCRC32 = h.Get(checksumHeader(ChecksumCRC32))
+	out.ChecksumCRC32C = h.Get(checksumHeader(ChecksumCRC32C))
+	out.ChecksumSHA1 = h.Get(checksumHeader(ChecksumSHA1))
+	out.ChecksumSHA256 = h.Get(checksumHeader(ChecksumSHA256))
+	out.Metadata = make(map[string]string)
+	for k, v := range h {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, metaPrefix) && len(v) > 0 {
+			out.Metadata[strings.TrimPrefix(k, metaPrefix)] = v[0]
+		}
+	}
+	return
+}
+
+// a gzip-encoded body, decoded as it's read. closing it closes both the
+// gzip stream and the response under it.
+type gunzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g gunzipBody) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+func (s SmartS3) del(ctx context.Context, req DeleteRequest) (err error) {
+	hreq, err := s.delRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("delete", start, hreq, resp, err) }()
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.New(resp.Status)
+		return responseError(resp)
 	}
 	return nil
 }
 
-func getObject(auth aws.Auth, req GetRequest) ([]byte, error) {
-	r, err := get(auth, req)
+// the signed request for a delete, ready to send
+func (s SmartS3) delRequest(ctx context.Context, req DeleteRequest) (*http.Request, error) {
+	u, resource, err := s.versionURL(req.Object, req.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	hreq, err := http.NewRequestWithContext(ctx, "DELETE", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
+	hreq.Header.Add("Date", format(now))
+	if req.IfMatch != "" {
+		hreq.Header.Add("If-Match", req.IfMatch)
+	}
+	if req.IfNoneMatch != "" {
+		hreq.Header.Add("If-None-Match", req.IfNoneMatch)
+	}
+	if err = s.authorize(hreq, resource, emptyPayload, now); err != nil {
+		return nil, err
+	}
+	return hreq, nil
+}
+
+func (s SmartS3) getObject(ctx context.Context, req GetRequest) ([]byte, error) {
+	res, err := s.get(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var body io.Reader = res.Body
+	if req.MaxBytes > 0 {
+		if res.ContentLength > req.MaxBytes {
+			return nil, fmt.Errorf("%w: %s is %d bytes, over the limit of %d", ErrTooLarge, print(req.Object), res.ContentLength, req.MaxBytes)
+		}
+		// a byte past the limit, to tell an object of exactly MaxBytes from a bigger one
+		body = io.LimitReader(body, req.MaxBytes+1)
+	}
 	var buf bytes.Buffer
-	_, err = io.Copy(&buf, r)
+	_, err = io.Copy(&buf, body)
 	if err != nil {
 		return nil, err
 	}
+	if req.MaxBytes > 0 && int64(buf.Len()) > req.MaxBytes {
+		return nil, fmt.Errorf("%w: %s is over the limit of %d bytes", ErrTooLarge, print(req.Object), req.MaxBytes)
+	}
 	return buf.Bytes(), nil
 }
 
-func put(auth aws.Auth, req PutRequest) (err error) {
-	u, err := createURL(req.Object)
+func (s SmartS3) put(ctx context.Context, req PutRequest) (out PutResult, err error) {
+	u, resource, err := s.createURL(req.Object)
 	if err != nil {
-		return err
+		return out, err
+	}
+	now := s.now()
+	// every attempt, whether by s.Retry or by net/http itself, reads a fresh
+	// reader from the factory, and the one it replaces is closed
+	var mu sync.Mutex
+	var reader io.ReadCloser
+	newBody := func() (io.ReadCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if reader != nil {
+			reader.Close()
+		}
+		r, err := req.ReaderFact.CreateReader()
+		if err != nil {
+			reader = nil
+			return nil, err
+		}
+		reader = r
+		body := s.throttle(r)
+		if req.Progress != nil {
+			body = &progressReader{r: body, total: int64(req.ReaderFact.Len()), f: req.Progress}
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{body, r}, nil
 	}
-	now := time.Now()
-	transport := http.DefaultTransport
-	reader, err := req.ReaderFact.CreateReader()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if reader != nil {
+			reader.Close()
+		}
+	}()
+	body, err := newBody()
 	if err != nil {
-		return err
+		return out, err
 	}
-	defer reader.Close()
-	hreq, err := http.NewRequest("PUT", u.String(), reader)
+	hreq, err := http.NewRequestWithContext(ctx, "PUT", u.String(), body)
 	if err != nil {
-		return err
+		return out, err
+	}
+	if _, once := req.ReaderFact.(onceReaderFact); !once {
+		// lets a retry re-read the body from the start
+		hreq.GetBody = newBody
 	}
 	hreq.Header.Add("Date", format(now))
+	if len(req.ContentType) == 0 && req.SniffContentType {
+		head, err := sniffHead(req.ReaderFact)
+		if err != nil {
+			return out, err
+		}
+		req.ContentType = sniffType(req.Object.Key, head)
+	}
 	if len(req.ContentType) == 0 {
 		req.ContentType = mimeType(req.Object.Key)
 	}
-	sig, err := signPut(u.Path, req.ContentType, auth, now)
-	if err != nil {
-		return
-	}
 	hreq.ContentLength = int64(req.ReaderFact.Len())
+	if hreq.ContentLength == 0 {
+		// otherwise go would treat the length as unknown and send chunked, which s3 refuses
+		hreq.Body = http.NoBody
+		hreq.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+	}
 	hreq.Header.Add("Content-Type", req.ContentType)
-	hreq.Header.Add("Content-Length", string(req.ReaderFact.Len()))
-	hreq.Header.Add("Authorization", "AWS "+auth.AccessKey+":"+sig)
-	resp, err := transport.RoundTrip(hreq)
+	s.expect(hreq)
+	if err = req.PutOptions.apply(hreq.Header); err != nil {
+		return
+	}
+	if req.ContentMD5 {
+		sum, err := readerFactMD5(req.ReaderFact)
+		if err != nil {
+			return out, err
+		}
+		hreq.Header.Add("Content-MD5", sum)
+	}
+	if req.ChecksumAlgorithm != "" {
+		r, err := req.ReaderFact.CreateReader()
+		if err != nil {
+			return out, err
+		}
+		err = req.PutOptions.checksum(r, hreq.Header)
+		r.Close()
+		if err != nil {
+			return out, err
+		}
+	}
+	if err = s.authorize(hreq, resource, unsignedPayload, now); err != nil {
+		return
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("put", start, hreq, resp, err) }()
 	if err != nil {
-		return err
+		return out, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return errors.New(resp.Status)
+		return out, responseError(resp)
 	}
-	return nil
+	return putResult(resp.Header), nil
 }
 
-func putObject(auth aws.Auth, req PutObjectRequest) (err error) {
-	u, err := createURL(req.Object)
+// asks for a 100 Continue before sending a large enough body
+func (s SmartS3) expect(hreq *http.Request) {
+	if s.ExpectContinue > 0 && hreq.ContentLength >= s.ExpectContinue {
+		hreq.Header.Set("Expect", "100-continue")
+	}
+}
+
+// the body is in memory, so every attempt simply rereads req.Data
+func (s SmartS3) putObject(ctx context.Context, req PutObjectRequest) (out PutResult, err error) {
+	hreq, err := s.putObjectRequest(ctx, req)
 	if err != nil {
-		return err
+		return out, err
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("put", start, hreq, resp, err) }()
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return out, responseError(resp)
+	}
+	return putResult(resp.Header), nil
+}
+
+// the signed request for a put of in-memory data, ready to send
+func (s SmartS3) putObjectRequest(ctx context.Context, req PutObjectRequest) (*http.Request, error) {
+	u, resource, err := s.createURL(req.Object)
+	if err != nil {
+		return nil, err
 	}
-	now := time.Now()
-	transport := http.DefaultTransport
+	now := s.now()
 	reader := bytes.NewBuffer(req.Data)
-	hreq, err := http.NewRequest("PUT", u.String(), reader)
+	hreq, err := http.NewRequestWithContext(ctx, "PUT", u.String(), reader)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if req.Progress != nil || s.BytesPerSecond > 0 {
+		newBody := func() (io.ReadCloser, error) {
+			body := s.throttle(bytes.NewReader(req.Data))
+			if req.Progress != nil {
+				body = &progressReader{r: body, total: int64(len(req.Data)), f: req.Progress}
+			}
+			return io.NopCloser(body), nil
+		}
+		hreq.Body, _ = newBody()
+		hreq.GetBody = newBody
 	}
 	hreq.Header.Add("Date", format(now))
+	if len(req.ContentType) == 0 && req.SniffContentType {
+		req.ContentType = sniffType(req.Object.Key, req.Data)
+	}
 	if len(req.ContentType) == 0 {
 		req.ContentType = mimeType(req.Object.Key)
 	}
-	sig, err := signPut(u.Path, req.ContentType, auth, now)
-	if err != nil {
-		return
-	}
 	hreq.ContentLength = int64(len(req.Data))
 	hreq.Header.Add("Content-Type", req.ContentType)
-	hreq.Header.Add("Content-Length", string(len(req.Data)))
-	hreq.Header.Add("Authorization", "AWS "+auth.AccessKey+":"+sig)
-	resp, err := transport.RoundTrip(hreq)
-	if err != nil {
+	s.expect(hreq)
+	if err = req.PutOptions.apply(hreq.Header); err != nil {
+		return nil, err
+	}
+	if req.ContentMD5 {
+		sum, _ := contentMD5(bytes.NewReader(req.Data))
+		hreq.Header.Add("Content-MD5", sum)
+	}
+	if err = req.PutOptions.checksum(bytes.NewReader(req.Data), hreq.Header); err != nil {
+		return nil, err
+	}
+	if err = s.authorize(hreq, resource, payloadHash(req.Data), now); err != nil {
+		return nil, err
+	}
+	return hreq, nil
+}
+
+// sets the headers for o's settings on an upload
+func (o PutOptions) apply(h http.Header) error {
+	if err := o.validate(); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return errors.New(resp.Status)
+	for k, v := range o.Metadata {
+		h.Set(metaPrefix+strings.ToLower(k), v)
+	}
+	if o.ACL != "" {
+		h.Set("X-Amz-Acl", o.ACL)
+	}
+	if o.ServerSideEncryption != "" {
+		h.Set("X-Amz-Server-Side-Encryption", o.ServerSideEncryption)
+	}
+	if o.KMSKeyID != "" {
+		h.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", o.KMSKeyID)
+	}
+	if o.StorageClass != "" {
+		h.Set("X-Amz-Storage-Class", o.StorageClass)
+	}
+	if o.ContentEncoding != "" {
+		h.Set("Content-Encoding", o.ContentEncoding)
+	}
+	if o.CacheControl != "" {
+		h.Set("Cache-Control", o.CacheControl)
+	}
+	if o.ContentDisposition != "" {
+		h.Set("Content-Disposition", o.ContentDisposition)
+	}
+	if !o.Expires.IsZero() {
+		h.Set("Expires", o.Expires.UTC().Format(http.TimeFormat))
+	}
+	if o.WebsiteRedirectLocation != "" {
+		h.Set("X-Amz-Website-Redirect-Location", o.WebsiteRedirectLocation)
+	}
+	if o.ObjectLockMode != "" {
+		h.Set("X-Amz-Object-Lock-Mode", o.ObjectLockMode)
+		h.Set("X-Amz-Object-Lock-Retain-Until-Date", o.ObjectLockRetainUntil.UTC().Format(time.RFC3339))
+	}
+	if o.ObjectLockLegalHold {
+		h.Set("X-Amz-Object-Lock-Legal-Hold", "ON")
 	}
 	return nil
 }
 
+func (o PutOptions) validate() error {
+	if o.ACL != "" && !cannedACLs[o.ACL] {
+		return fmt.Errorf("unknown canned acl %q", o.ACL)
+	}
+	switch o.ServerSideEncryption {
+	case "", SSES3:
+		if o.KMSKeyID != "" {
+			return errors.New("a kms key id needs server side encryption " + SSEKMS)
+		}
+	case SSEKMS:
+	default:
+		return fmt.Errorf("unknown server side encryption %q", o.ServerSideEncryption)
+	}
+	if o.StorageClass != "" && !storageClasses[o.StorageClass] {
+		return fmt.Errorf("unknown storage class %q", o.StorageClass)
+	}
+	if o.ObjectLockMode != "" || !o.ObjectLockRetainUntil.IsZero() {
+		if err := checkLock(o.ObjectLockMode, o.ObjectLockRetainUntil); err != nil {
+			return err
+		}
+	}
+	if o.ChecksumAlgorithm != "" {
+		if _, err := newChecksum(o.ChecksumAlgorithm); err != nil {
+			return err
+		}
+	} else if o.Checksum != "" {
+		return errors.New("a checksum needs a checksum algorithm")
+	}
+	return nil
+}
+
+var cannedACLs = map[string]bool{
+	"private":                   true,
+	"public-read":               true,
+	"public-read-write":         true,
+	"authenticated-read":        true,
+	"aws-exec-read":             true,
+	"bucket-owner-read":         true,
+	"bucket-owner-full-control": true,
+	"log-delivery-write":        true,
+}
+
+var storageClasses = map[string]bool{
+	"STANDARD":            true,
+	"REDUCED_REDUNDANCY":  true,
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER":             true,
+	"GLACIER_IR":          true,
+	"DEEP_ARCHIVE":        true,
+}
+
+func putResult(h http.Header) PutResult {
+	return PutResult{
+		ETag:                 h.Get("ETag"),
+		VersionID:            h.Get("X-Amz-Version-Id"),
+		ServerSideEncryption: h.Get("X-Amz-Server-Side-Encryption"),
+		KMSKeyID:             h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+	}
+}
+
 func format(t time.Time) string {
 	return t.UTC().Format(time.RFC1123Z)
 }
 
-func signGet(path string, a aws.Auth, t time.Time) (string, error) {
-	return sign(a, "GET"+N+N+N+format(t)+N+path)
+// sets the authorization header on a fully-built request, using signature v4
+// when configured and the legacy v2 scheme otherwise
+func (s SmartS3) authorize(hreq *http.Request, path string, payload string, t time.Time) error {
+	auth, err := s.credentials()
+	if err != nil {
+		return err
+	}
+	if auth.SessionToken != "" {
+		hreq.Header.Set("X-Amz-Security-Token", auth.SessionToken)
+	}
+	if s.RequesterPays {
+		hreq.Header.Set("X-Amz-Request-Payer", "requester")
+	}
+	if s.SigV4 {
+		a := auth
+		a.Region = s.regionFor(bucketOf(path))
+		toSign, err := signV4(a, hreq, payload, t)
+		s.trace(TraceEvent{Method: hreq.Method, URL: hreq.URL.String(), StringToSign: redactToken(toSign, auth.SessionToken)})
+		return err
+	}
+	sig, toSign, err := signV2(hreq.Method, path, hreq.Header.Get("Content-Md5"), hreq.Header.Get("Content-Type"), amzHeaders(hreq.Header), auth, t)
+	if err != nil {
+		return err
+	}
+	s.trace(TraceEvent{Method: hreq.Method, URL: hreq.URL.String(), StringToSign: redactToken(toSign, auth.SessionToken)})
+	hreq.Header.Set("Authorization", "AWS "+auth.AccessKey+":"+sig)
+	return nil
+}
+
+// Auth, or the current credentials from Credentials when it's set: those
+// retrieved last, unless they've expired
+func (s SmartS3) credentials() (aws.Auth, error) {
+	p := s.Credentials
+	if p == nil {
+		return s.Auth, nil
+	}
+	// providers that can't be compared, so can't be told apart, aren't cached
+	cache := s.state != nil && reflect.TypeOf(p).Comparable()
+	if cache {
+		s.state.mu.Lock()
+		defer s.state.mu.Unlock()
+		if s.state.provider == p && !p.IsExpired() {
+			return s.state.auth, nil
+		}
+	}
+	a, err := p.Retrieve()
+	if err != nil {
+		return aws.Auth{}, fmt.Errorf("can't get credentials: %w", err)
+	}
+	if cache {
+		s.state.provider, s.state.auth = p, a
+	}
+	return a, nil
+}
+
+// the signature, and the string it's of
+func signV2(method, path, md5, ct, amz string, a aws.Auth, t time.Time) (sig, toSign string, err error) {
+	toSign = stringToSignV2(method, path, md5, ct, amz, t)
+	sig, err = sign(a, toSign)
+	return
+}
+
+func stringToSignV2(method, path, md5, ct, amz string, t time.Time) string {
+	return method + N + md5 + N + ct + N + format(t) + N + amz + path
+}
+
+// exactly what a v2 signature of a request would be computed over, for
+// comparing with what s3 reports alongside a SignatureDoesNotMatch. path is
+// the path-style resource, e.g. "/bucket/key" or "/bucket/key?acl"; the
+// Content-MD5 and x-amz-* headers are taken from headers, which may be nil.
+func CanonicalStringToSign(method, path, contentType string, headers http.Header, t time.Time) string {
+	return stringToSignV2(method, path, headers.Get("Content-Md5"), contentType, amzHeaders(headers), t)
 }
 
-func signPut(path string, ct string, a aws.Auth, t time.Time) (string, error) {
-	return sign(a, "PUT"+N+N+ct+N+format(t)+N+path)
+// the canonicalized x-amz-* headers of a v2 string to sign: lowercased, sorted, one per line
+func amzHeaders(h http.Header) string {
+	var names []string
+	for k := range h {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + ":" + strings.Join(h.Values(k), ",") + N)
+	}
+	return b.String()
 }
 
-func signList(path string, a aws.Auth, t time.Time) (string, error) {
-	return sign(a, "GET"+N+N+N+format(t)+N+path)
+// base64 md5 digest, as the Content-MD5 header wants it
+func contentMD5(r io.Reader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
 }
 
-func signDelete(path string, a aws.Auth, t time.Time) (string, error) {
-	return sign(a, "DELETE"+N+N+N+format(t)+N+path)
+// digests a fresh reader from f, so the one actually sent starts at the beginning
+func readerFactMD5(f goutil.ReaderFactory) (string, error) {
+	r, err := f.CreateReader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return contentMD5(r)
 }
 
 func sign(a aws.Auth, toSign string) (signature string, err error) {
+	shown := redactToken(toSign, a.SessionToken)
+	if a.SecretKey == "" {
+		return "", &SigningError{StringToSign: shown, Err: errNoSecretKey}
+	}
 	h := hmac.New(sha1.New, []byte(a.SecretKey))
 	if _, err = h.Write([]byte(toSign)); err != nil {
-		return
+		return "", &SigningError{StringToSign: shown, Err: err}
 	}
 	sig := h.Sum(nil)
 	buf := new(bytes.Buffer)
 	encoder := base64.NewEncoder(base64.StdEncoding, buf)
 	if _, err = encoder.Write(sig); err != nil {
-		return
+		return "", &SigningError{StringToSign: shown, Err: err}
 	}
 	if err = encoder.Close(); err != nil {
-		return
+		return "", &SigningError{StringToSign: shown, Err: err}
 	}
 	signature = buf.String()
 	return
 }
 
-func esc(s string) string {
-	if true {
-		return url.QueryEscape(s)
-	} else {
-		return strings.Replace(s, " ", "+", -1)
+// percent-encodes a key for a url path one segment at a time, so the slashes
+// between "folders" survive and spaces become %20 rather than +
+func escapePath(key string) string {
+	segs := strings.Split(key, "/")
+	for i, seg := range segs {
+		segs[i] = uriEncode(seg)
 	}
+	return strings.Join(segs, "/")
 }
 
 func print(v interface{}) string {
@@ -0,0 +1,455 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+	"github.com/xoba/goutil/aws/s3/mock"
+	"github.com/xoba/goutil/aws4"
+)
+
+// a client of a fresh mock holding bucket "bkt"
+func newMockClient(t *testing.T) SmartS3 {
+	srv := mock.NewServer("bkt")
+	t.Cleanup(srv.Close)
+	return SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	text := bytes.Repeat([]byte("a log line\n"), 1000)
+	var z bytes.Buffer
+	zw := gzip.NewWriter(&z)
+	zw.Write(text)
+	zw.Close()
+	o := Object{"bkt", "log.gz"}
+	req := PutObjectRequest{Object: o, Data: z.Bytes()}
+	req.ContentEncoding = "gzip"
+	if err := s.PutObjectContext(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Head(HeadRequest{Object: o})
+	if err != nil || info.ContentEncoding != "gzip" {
+		t.Fatalf("%v: content encoding %q", err, info.ContentEncoding)
+	}
+	raw, err := s.GetObjectContext(ctx, GetRequest{Object: o})
+	if err != nil || !bytes.Equal(raw, z.Bytes()) {
+		t.Fatalf("without Decompress: %v, %d bytes", err, len(raw))
+	}
+	r, err := s.GetContext(ctx, GetRequest{Object: o, Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, text) {
+		t.Fatalf("with Decompress: %v, %d bytes", err, len(got))
+	}
+}
+
+func TestRequestModifier(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		ids [][]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, r.Header.Values("X-Trace-Id"))
+		if len(ids) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	s := SmartS3{
+		Auth:     aws.Auth{AccessKey: "a", SecretKey: "b"},
+		Endpoint: srv.URL,
+		Retry:    RetryPolicy{MaxAttempts: 2, Delay: time.Millisecond},
+		RequestModifier: func(r *http.Request) {
+			r.Header.Add("X-Trace-Id", "t1")
+		},
+	}
+	if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("%d attempts, want 2", len(ids))
+	}
+	for i, v := range ids {
+		if len(v) != 1 || v[0] != "t1" {
+			t.Errorf("attempt %d: X-Trace-Id %q", i+1, v)
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	ctx := context.Background()
+	for _, c := range []struct{ ua, want string }{
+		{"", DefaultUserAgent},
+		{"myapp/2", DefaultUserAgent + " myapp/2"},
+	} {
+		s.UserAgent = c.ua
+		if _, err := s.GetObjectContext(ctx, GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+// the content type the mock stored for data put at key
+func putContentType(t *testing.T, s SmartS3, key, contentType string, data []byte, opts PutOptions) string {
+	o := Object{"bkt", key}
+	req := PutObjectRequest{Object: o, ContentType: contentType, Data: data, PutOptions: opts}
+	if err := s.PutObjectContext(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Head(HeadRequest{Object: o})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.ContentType
+}
+
+func TestDefaultContentType(t *testing.T) {
+	s := newMockClient(t)
+	for key, want := range map[string]string{
+		"page.html":  "text/html; charset=utf-8",
+		"data":       "application/octet-stream",
+		"data.nope1": "application/octet-stream",
+	} {
+		if got := putContentType(t, s, key, "", []byte("<html></html>"), PutOptions{}); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestRegisterMIME(t *testing.T) {
+	s := newMockClient(t)
+	RegisterMIME("Synth62", "application/x-synth")
+	if got := putContentType(t, s, "a.synth62", "", nil, PutOptions{}); got != "application/x-synth" {
+		t.Errorf("registered: got %q", got)
+	}
+	if got := putContentType(t, s, "b.synth62", "text/plain", nil, PutOptions{}); got != "text/plain" {
+		t.Errorf("given: got %q", got)
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	s := newMockClient(t)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	sniff := PutOptions{SniffContentType: true}
+	if got := putContentType(t, s, "img", "", png, sniff); got != "image/png" {
+		t.Errorf("sniffed: got %q", got)
+	}
+	if got := putContentType(t, s, "img.txt", "", png, sniff); got != "text/plain; charset=utf-8" {
+		t.Errorf("by extension: got %q", got)
+	}
+	if got := putContentType(t, s, "img", "", png, PutOptions{}); got != "application/octet-stream" {
+		t.Errorf("not sniffed: got %q", got)
+	}
+
+	o := Object{"bkt", "streamed"}
+	if err := s.PutContext(context.Background(), PutRequest{Object: o, ReaderFact: BytesReaderFact(png), PutOptions: sniff}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Head(HeadRequest{Object: o}); err != nil || info.ContentType != "image/png" {
+		t.Errorf("from a reader: got %q, %v", info.ContentType, err)
+	}
+}
+
+// a ReaderFact of n zero bytes, counting those read
+type countingFact struct {
+	n    int64
+	read *int64
+}
+
+func (f countingFact) CreateReader() (io.ReadCloser, error) {
+	return io.NopCloser(&countingReader{io.LimitReader(zeros{}, f.n), f.read}), nil
+}
+
+func (f countingFact) Len() uint64 {
+	return uint64(f.n)
+}
+
+type countingReader struct {
+	r    io.Reader
+	read *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	*r.read += int64(n)
+	return n, err
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestExpectContinue(t *testing.T) {
+	var expects []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expects = append(expects, r.Header.Get("Expect"))
+		// refused unread, as s3 would a bad signature
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>AccessDenied</Code></Error>")
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, ExpectContinue: 1 << 20}
+	var read int64
+	err := s.PutContext(context.Background(), PutRequest{Object: Object{"bkt", "k"}, ReaderFact: countingFact{50 << 20, &read}})
+	var e *S3Error
+	if !errors.As(err, &e) || e.Code != "AccessDenied" {
+		t.Fatalf("got %v", err)
+	}
+	if read > 1<<20 {
+		t.Errorf("sent %d bytes of a refused body", read)
+	}
+	if err := s.PutObjectContext(context.Background(), PutObjectRequest{Object: Object{"bkt", "small"}, Data: []byte("x")}); err == nil {
+		t.Fatal("small put wasn't refused")
+	}
+	if len(expects) != 2 || expects[0] != "100-continue" || expects[1] != "" {
+		t.Errorf("got Expect headers %q", expects)
+	}
+}
+
+// the url a get of o would be sent to
+func getURL(t *testing.T, s SmartS3, o Object) string {
+	hreq, err := s.PrepareGet(context.Background(), GetRequest{Object: o})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hreq.URL.String()
+}
+
+func TestEndpoint(t *testing.T) {
+	o := Object{"bkt", "k"}
+	for endpoint, want := range map[string]string{
+		"":                           "https://bkt." + DefaultEndpoint + "/k",
+		"s3.eu-west-1.amazonaws.com": "https://bkt.s3.eu-west-1.amazonaws.com/k",
+		"https://s3.example.com/":    "https://bkt.s3.example.com/k",
+		"http://127.0.0.1:9000":      "http://127.0.0.1:9000/bkt/k",
+	} {
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: endpoint}
+		if got := getURL(t, s, o); got != want {
+			t.Errorf("%q: got %s, want %s", endpoint, got, want)
+		}
+	}
+}
+
+func TestVirtualHostStyle(t *testing.T) {
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: "s3.example.com"}
+	var signed []string
+	s.Trace = func(e TraceEvent) { signed = append(signed, e.StringToSign) }
+	if got := getURL(t, s, Object{"bkt", "a/b c"}); got != "https://bkt.s3.example.com/a/b%20c" {
+		t.Errorf("virtual host: got %s", got)
+	}
+	// v2 signs the path-style resource whichever is sent
+	if !strings.HasSuffix(signed[0], "\n/bkt/a/b%20c") {
+		t.Errorf("signed %q", signed[0])
+	}
+	if got := getURL(t, s, Object{"my.bucket", "k"}); got != "https://s3.example.com/my.bucket/k" {
+		t.Errorf("dotted bucket: got %s", got)
+	}
+	s.PathStyle = true
+	if got := getURL(t, s, Object{"bkt", "k"}); got != "https://s3.example.com/bkt/k" {
+		t.Errorf("PathStyle: got %s", got)
+	}
+}
+
+func TestFIPSDualStack(t *testing.T) {
+	o := Object{"bkt", "k"}
+	for _, c := range []struct {
+		fips, dual bool
+		want       string
+	}{
+		{true, false, "https://bkt.s3-fips.us-west-2.amazonaws.com/k"},
+		{false, true, "https://bkt.s3.dualstack.us-west-2.amazonaws.com/k"},
+		{true, true, "https://bkt.s3-fips.dualstack.us-west-2.amazonaws.com/k"},
+	} {
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b", Region: "us-west-2"}, UseFIPS: c.fips, UseDualStack: c.dual}
+		if got := getURL(t, s, o); got != c.want {
+			t.Errorf("fips %v, dualstack %v: got %s, want %s", c.fips, c.dual, got, c.want)
+		}
+	}
+	// an explicit endpoint wins
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: "http://127.0.0.1:9000", UseFIPS: true}
+	if got := getURL(t, s, o); got != "http://127.0.0.1:9000/bkt/k" {
+		t.Errorf("with an endpoint: got %s", got)
+	}
+}
+
+// keys with characters that need escaping, or that url parsing might mangle
+var awkwardKeys = []string{"a b/c+d", "é/ü", "q?x=1&y=2", "frag#ment", "tilde~star*", "pct%20", "semi;colon:equals=", "dots/./../end"}
+
+func TestKeyEncoding(t *testing.T) {
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: "http://127.0.0.1:9000"}
+	for key, want := range map[string]string{
+		"a b/c+d":     "/bkt/a%20b/c%2Bd",
+		"é":           "/bkt/%C3%A9",
+		"q?x=1&y=2":   "/bkt/q%3Fx%3D1%26y%3D2",
+		"tilde~star*": "/bkt/tilde~star%2A",
+		"dir//x":      "/bkt/dir//x",
+	} {
+		hreq, err := s.PrepareGet(context.Background(), GetRequest{Object: Object{"bkt", key}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hreq.URL.EscapedPath(); got != want {
+			t.Errorf("%q: got %s, want %s", key, got, want)
+		}
+	}
+
+	// and each comes back as itself, whichever way it's signed
+	for _, v4 := range []bool{false, true} {
+		m := newMockClient(t)
+		m.SigV4 = v4
+		ctx := context.Background()
+		for _, k := range awkwardKeys {
+			if err := m.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", k}, Data: []byte(k)}); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := m.GetObjectContext(ctx, GetRequest{Object: Object{"bkt", k}}); err != nil || string(b) != k {
+				t.Errorf("v4 %v, %q: got %q, %v", v4, k, b, err)
+			}
+		}
+		l, err := m.ListAllContext(ctx, ListRequest{Bucket: "bkt"})
+		if err != nil || len(l.Contents) != len(awkwardKeys) {
+			t.Errorf("v4 %v: listed %+v, %v", v4, l.Contents, err)
+		}
+	}
+}
+
+// a server that checks each request's v2 or v4 signature over the path and
+// headers as they arrived, with the credentials "a" and "b"
+func newVerifyingServer(t *testing.T) *httptest.Server {
+	auth := aws.Auth{AccessKey: "a", SecretKey: "b"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get("Authorization")
+		var want string
+		if strings.HasPrefix(got, "AWS4-HMAC-SHA256 ") {
+			d, err := time.Parse(iso8601BasicFormat, r.Header.Get("X-Amz-Date"))
+			if err != nil {
+				t.Error(err)
+			}
+			signed := got[strings.Index(got, "SignedHeaders=")+len("SignedHeaders="):]
+			signed = signed[:strings.Index(signed, ",")]
+			again := &http.Request{Method: r.Method, URL: &url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}, Host: r.Host, Header: make(http.Header)}
+			for _, k := range strings.Split(signed, ";") {
+				if k != "host" {
+					again.Header[http.CanonicalHeaderKey(k)] = r.Header.Values(k)
+				}
+			}
+			svc := aws4.Service{Name: "s3", Region: auth.GetRegion()}
+			if _, err := svc.SignHashed(&aws4.Keys{AccessKey: auth.AccessKey, SecretKey: auth.SecretKey}, again, r.Header.Get("X-Amz-Content-Sha256"), d); err != nil {
+				t.Error(err)
+			}
+			want = again.Header.Get("Authorization")
+		} else {
+			d, err := time.Parse(time.RFC1123Z, r.Header.Get("Date"))
+			if err != nil {
+				t.Error(err)
+			}
+			sig, err := sign(auth, CanonicalStringToSign(r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), r.Header, d))
+			if err != nil {
+				t.Error(err)
+			}
+			want = "AWS a:" + sig
+		}
+		if got != want {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s for %s</Message></Error>", got, r.URL.EscapedPath())
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSignedAsSent(t *testing.T) {
+	srv := newVerifyingServer(t)
+	for _, v4 := range []bool{false, true} {
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, SigV4: v4}
+		for _, k := range awkwardKeys {
+			if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", k}}); err != nil {
+				t.Errorf("v4 %v, %q: %v", v4, k, err)
+			}
+		}
+	}
+}
+
+func TestCanonicalStringToSign(t *testing.T) {
+	d := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := http.Header{}
+	h.Set("X-Amz-Meta-B", "2")
+	h.Set("X-Amz-Acl", "private")
+	h.Set("Content-Md5", "bWQ1")
+	for _, c := range []struct {
+		method, ct string
+		headers    http.Header
+		want       string
+	}{
+		{"GET", "", http.Header{}, "GET\n\n\nThu, 02 Jan 2020 03:04:05 +0000\n/bkt/k"},
+		{"PUT", "text/plain", h, "PUT\nbWQ1\ntext/plain\nThu, 02 Jan 2020 03:04:05 +0000\nx-amz-acl:private\nx-amz-meta-b:2\n/bkt/k"},
+		{"DELETE", "", http.Header{}, "DELETE\n\n\nThu, 02 Jan 2020 03:04:05 +0000\n/bkt/k"},
+	} {
+		if got := CanonicalStringToSign(c.method, "/bkt/k", c.ct, c.headers, d); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.method, got, c.want)
+		}
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	d := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	prepare := func(v4 bool, token string) *http.Request {
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b", SessionToken: token}, Endpoint: "http://127.0.0.1", SigV4: v4}
+		s.Now = func() time.Time { return d }
+		r, err := s.PrepareGet(context.Background(), GetRequest{Object: Object{"bkt", "k"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	for _, v4 := range []bool{false, true} {
+		with, without := prepare(v4, "tok"), prepare(v4, "")
+		if got := with.Header.Get("X-Amz-Security-Token"); got != "tok" {
+			t.Errorf("v4 %v: token header %q", v4, got)
+		}
+		if without.Header.Get("X-Amz-Security-Token") != "" {
+			t.Errorf("v4 %v: token header sent without a token", v4)
+		}
+		if with.Header.Get("Authorization") == without.Header.Get("Authorization") {
+			t.Errorf("v4 %v: the token isn't signed", v4)
+		}
+	}
+	sig, err := sign(aws.Auth{SecretKey: "b"}, "GET\n\n\nThu, 02 Jan 2020 03:04:05 +0000\nx-amz-security-token:tok\n/bkt/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prepare(false, "tok").Header.Get("Authorization"); got != "AWS a:"+sig {
+		t.Errorf("got %q, want %q", got, "AWS a:"+sig)
+	}
+}
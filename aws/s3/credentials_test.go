@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// hands out numbered keys, each expiring as soon as it's been used once
+type onceProvider struct {
+	retrieved, used int
+}
+
+func (p *onceProvider) Retrieve() (aws.Auth, error) {
+	p.retrieved++
+	p.used = 0
+	return aws.Auth{AccessKey: fmt.Sprint("key", p.retrieved), SecretKey: "s", SessionToken: "t"}, nil
+}
+
+func (p *onceProvider) IsExpired() bool {
+	p.used++
+	return p.retrieved == 0 || p.used > 1
+}
+
+func TestCredentialsRefresh(t *testing.T) {
+	p := &onceProvider{}
+	s := newMockClient(t)
+	s.state = new(clientState)
+	s.Credentials = p
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", "k"}, Data: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first request retrieves, the second reuses, the third finds them expired
+	if p.retrieved != 2 {
+		t.Errorf("retrieved %d times, want 2", p.retrieved)
+	}
+	u, err := s.PresignGet(Object{"bkt", "k"}, time.Minute)
+	if err != nil || !strings.Contains(u, "AWSAccessKeyId=key") {
+		t.Fatal(u, err)
+	}
+}
+
+func TestCredentialsWithoutState(t *testing.T) {
+	p := &onceProvider{}
+	s := newMockClient(t)
+	s.Credentials = p
+	for i := 0; i < 2; i++ {
+		if err := s.PutObjectContext(context.Background(), PutObjectRequest{Object: Object{"bkt", "k"}, Data: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.retrieved != 2 {
+		t.Errorf("retrieved %d times, want one per request", p.retrieved)
+	}
+}
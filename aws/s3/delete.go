@@ -0,0 +1,128 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// most keys s3 accepts in one multi-object delete
+const MaxDeleteKeys = 1000
+
+type deleteObjects struct {
+	XMLName xml.Name       `xml:"Delete"`
+	Quiet   bool           `xml:"Quiet"`
+	Objects []deleteObject `xml:"Object"`
+}
+
+type deleteObject struct {
+	Key string
+}
+
+// the outcome of DeleteMulti, key by key
+type DeleteResult struct {
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+type DeletedObject struct {
+	Key       string
+	VersionID string `xml:"VersionId"`
+}
+
+type DeleteError struct {
+	Key, Code, Message string
+}
+
+// deletes keys from bucket, batching them MaxDeleteKeys per request.
+// per-key failures are reported in the result, not as an error.
+func (s SmartS3) DeleteMulti(ctx context.Context, bucket string, keys []string) (out DeleteResult, err error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return out, err
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > MaxDeleteKeys {
+			n = MaxDeleteKeys
+		}
+		res, err := s.deleteBatch(ctx, bucket, keys[:n])
+		if err != nil {
+			return out, err
+		}
+		out.Deleted = append(out.Deleted, res.Deleted...)
+		out.Errors = append(out.Errors, res.Errors...)
+		keys = keys[n:]
+	}
+	return out, nil
+}
+
+func (s SmartS3) deleteBatch(ctx context.Context, bucket string, keys []string) (DeleteResult, error) {
+	req := deleteObjects{Objects: make([]deleteObject, len(keys))}
+	for i, k := range keys {
+		req.Objects[i].Key = k
+	}
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	sum, err := contentMD5(bytes.NewReader(body))
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	u, resource, err := s.subresourceURL(Object{Bucket: bucket}, url.Values{"delete": {""}})
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	h := http.Header{"Content-Type": {"application/xml"}, "Content-Md5": {sum}}
+	f := func(s SmartS3) (interface{}, error) {
+		var out DeleteResult
+		err := s.doXML(ctx, "POST", u, resource, h, body, &out)
+		return out, err
+	}
+	v, err := s.retry(ctx, bucket, fmt.Sprintf("delete %d keys from %s", len(keys), bucket), f)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	return v.(DeleteResult), nil
+}
+
+// deletes every key in bucket under prefix, a page at a time, returning how
+// many went and how many s3 refused. with dryRun it only lists and counts
+// the keys it would have deleted.
+func (s SmartS3) DeleteAll(ctx context.Context, bucket, prefix string, dryRun bool) (deleted, failed int, err error) {
+	it := s.ListIterContext(ctx, ListRequest{Bucket: bucket, Prefix: prefix})
+	var batch []string
+	flush := func() error {
+		if dryRun {
+			deleted += len(batch)
+		} else if len(batch) > 0 {
+			res, err := s.DeleteMulti(ctx, bucket, batch)
+			if err != nil {
+				return err
+			}
+			deleted += len(res.Deleted)
+			failed += len(res.Errors)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		c, ok := it.Next()
+		if !ok {
+			break
+		}
+		batch = append(batch, c.Key)
+		if len(batch) == MaxDeleteKeys {
+			if err := flush(); err != nil {
+				return deleted, failed, err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return deleted, failed, err
+	}
+	return deleted, failed, flush()
+}
@@ -0,0 +1,99 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// the error document s3 returns for a failed request; use errors.As to recover it
+type S3Error struct {
+	HTTPStatusCode int    `xml:"-"`
+	Status         string `xml:"-"`
+	Code           string
+	Message        string
+	RequestID      string `xml:"RequestId"`
+	HostID         string `xml:"HostId"`
+	// where a redirect says the bucket really lives
+	Region   string
+	Endpoint string
+	// s3's clock, when it refused the request as RequestTimeTooSkewed
+	ServerTime string
+}
+
+// the ids s3 gives every response, x-amz-request-id and x-amz-id-2, which
+// aws support asks for when looking into a request
+type RequestIDs struct {
+	RequestID, HostID string
+}
+
+func requestIDs(h http.Header) RequestIDs {
+	return RequestIDs{RequestID: h.Get("X-Amz-Request-Id"), HostID: h.Get("X-Amz-Id-2")}
+}
+
+func (e *S3Error) RequestIDs() RequestIDs {
+	return RequestIDs{RequestID: e.RequestID, HostID: e.HostID}
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" && e.RequestID == "" {
+		return e.Status
+	}
+	if e.Code == "" {
+		return fmt.Sprintf("%s (request %s)", e.Status, e.RequestID)
+	}
+	return fmt.Sprintf("%s: %s: %s (request %s)", e.Status, e.Code, e.Message, e.RequestID)
+}
+
+// a request couldn't be signed here, as opposed to being refused by s3
+type SigningError struct {
+	// with any session token redacted, as in TraceEvent
+	StringToSign string
+	Err          error
+}
+
+func (e *SigningError) Error() string {
+	return "can't sign request: " + e.Err.Error()
+}
+
+func (e *SigningError) Unwrap() error {
+	return e.Err
+}
+
+var errNoSecretKey = errors.New("no secret key")
+
+// lets errors.Is match a 412 to ErrPreconditionFailed
+func (e *S3Error) Is(target error) bool {
+	return target == ErrPreconditionFailed && e.HTTPStatusCode == http.StatusPreconditionFailed
+}
+
+// builds an error from a non-2xx response, parsing the xml body when there is one
+func responseError(resp *http.Response) error {
+	out := &S3Error{HTTPStatusCode: resp.StatusCode, Status: resp.Status}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err == nil && len(body) > 0 {
+		var doc S3Error
+		if xml.Unmarshal(body, &doc) == nil {
+			doc.HTTPStatusCode, doc.Status = out.HTTPStatusCode, out.Status
+			out = &doc
+		}
+	}
+	// heads, and errors from proxies or load balancers, have no document to carry them
+	ids := requestIDs(resp.Header)
+	if out.RequestID == "" {
+		out.RequestID = ids.RequestID
+	}
+	if out.HostID == "" {
+		out.HostID = ids.HostID
+	}
+	if r := resp.Header.Get("X-Amz-Bucket-Region"); r != "" {
+		out.Region = r
+	}
+	if l, err := url.Parse(resp.Header.Get("Location")); err == nil && l.Host != "" && out.Endpoint == "" {
+		out.Endpoint = l.Scheme + "://" + l.Host
+	}
+	return out
+}
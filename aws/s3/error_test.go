@@ -0,0 +1,135 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil"
+	"github.com/xoba/goutil/aws"
+)
+
+// from the s3 error responses documentation
+const errorXML = `<?xml version="1.0" encoding="UTF-8"?>
+<Error>
+  <Code>NoSuchKey</Code>
+  <Message>The resource you requested does not exist</Message>
+  <Resource>/mybucket/myfoto.jpg</Resource>
+  <RequestId>4442587FB7D0A2F9</RequestId>
+</Error>`
+
+func TestErrorDocument(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, errorXML)
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	_, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}})
+	var e *S3Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want an S3Error", err)
+	}
+	want := S3Error{
+		HTTPStatusCode: 404,
+		Status:         "404 Not Found",
+		Code:           "NoSuchKey",
+		Message:        "The resource you requested does not exist",
+		RequestID:      "4442587FB7D0A2F9",
+	}
+	if *e != want {
+		t.Errorf("got %+v\nwant %+v", *e, want)
+	}
+	if got := e.Error(); got != "404 Not Found: NoSuchKey: The resource you requested does not exist (request 4442587FB7D0A2F9)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestErrorWithoutDocument(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "<html>bad gateway</html>")
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	_, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}})
+	var e *S3Error
+	if !errors.As(err, &e) || e.HTTPStatusCode != 502 || e.Code != "" || e.Error() != "502 Bad Gateway" {
+		t.Errorf("got %#v", err)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Request-Id", "RID")
+		w.Header().Set("X-Amz-Id-2", "HID")
+		if r.Method != "HEAD" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "<html>nope</html>")
+		}
+	}))
+	defer srv.Close()
+	var events []TraceEvent
+	s := SmartS3{
+		Auth:     aws.Auth{AccessKey: "a", SecretKey: "b"},
+		Endpoint: srv.URL,
+		Trace:    func(e TraceEvent) { events = append(events, e) },
+	}
+	want := RequestIDs{RequestID: "RID", HostID: "HID"}
+	_, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}})
+	var e *S3Error
+	if !errors.As(err, &e) || e.RequestIDs() != want {
+		t.Errorf("got %v", err)
+	}
+	info, err := s.Head(HeadRequest{Object: Object{"bkt", "k"}})
+	if err != nil || info.IDs != want {
+		t.Errorf("head: got %+v, %v", info.IDs, err)
+	}
+	if got := events[len(events)-1].IDs; got != want {
+		t.Errorf("trace: got %+v", got)
+	}
+}
+
+func TestPreconditionFailed(t *testing.T) {
+	var got []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		io.WriteString(w, "<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>")
+	}))
+	defer srv.Close()
+	s := SmartS3{
+		Auth:     aws.Auth{AccessKey: "a", SecretKey: "b"},
+		Endpoint: srv.URL,
+		Strat:    goutil.RetryBackoffStrat{Delay: time.Millisecond, Retries: 3},
+	}
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	for _, c := range []struct {
+		name, header string
+		f            func() error
+	}{
+		{"delete", "If-Match", func() error {
+			return s.DeleteContext(ctx, DeleteRequest{Object: o, IfMatch: `"e1"`})
+		}},
+		{"copy", "X-Amz-Copy-Source-If-Match", func() error {
+			_, err := s.Copy(ctx, Object{"bkt", "src"}, o, CopyOptions{IfMatch: `"e1"`})
+			return err
+		}},
+	} {
+		got = nil
+		err := c.f()
+		if !errors.Is(err, ErrPreconditionFailed) {
+			t.Errorf("%s: got %v, want ErrPreconditionFailed", c.name, err)
+		}
+		if len(got) != 1 {
+			t.Errorf("%s: asked %d times", c.name, len(got))
+		} else if v := got[0].Get(c.header); v != `"e1"` {
+			t.Errorf("%s: %s %q", c.name, c.header, v)
+		}
+	}
+}
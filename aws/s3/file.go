@@ -0,0 +1,132 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/xoba/goutil"
+)
+
+type DownloadOptions struct {
+	// write to a temporary file beside path and rename it into place once complete,
+	// so a failure never leaves a partial file at path
+	Atomic bool
+	// called as the body arrives, with the bytes so far and the object's size
+	Progress ProgressFunc
+}
+
+// streams o to the file at path, removing whatever was written if the download fails
+func (s SmartS3) GetToFile(ctx context.Context, o Object, path string, opts DownloadOptions) (err error) {
+	res, err := s.GetWithResult(ctx, GetRequest{Object: o})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var f *os.File
+	if opts.Atomic {
+		f, err = os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	} else {
+		f, err = os.Create(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	var body io.Reader = res.Body
+	if opts.Progress != nil {
+		body = &progressReader{r: res.Body, total: res.TotalSize, f: opts.Progress}
+	}
+	if _, err = io.Copy(f, body); err != nil {
+		return err
+	}
+	if opts.Atomic {
+		// temp files start out private; give it the permissions os.Create would have
+		if err = f.Chmod(0644); err != nil {
+			return err
+		}
+		if err = f.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if opts.Atomic {
+		return os.Rename(f.Name(), path)
+	}
+	return nil
+}
+
+// uploads the file at path to o: in one put when it fits in a part, and
+// otherwise in parts read concurrently from the file
+func (s SmartS3) UploadFile(ctx context.Context, o Object, path string, opts UploadOptions) (PutResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return PutResult{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return PutResult{}, err
+	}
+	if opts.ContentType == "" && !opts.SniffContentType {
+		opts.ContentType = mimeType(o.Key)
+	}
+	if partSize, _ := opts.sizes(); info.Size() > partSize {
+		return s.UploadLarge(ctx, o, f, info.Size(), opts)
+	}
+	return s.PutWithResult(ctx, PutRequest{
+		Object:      o,
+		ContentType: opts.ContentType,
+		ReaderFact:  fileReaderFact{path, info.Size()},
+		PutOptions:  PutOptions{SniffContentType: opts.SniffContentType},
+	})
+}
+
+// a ReaderFact for b, each reader starting from the beginning
+func BytesReaderFact(b []byte) goutil.ReaderFactory {
+	return goutil.BufferReaderFact{Buffer: b}
+}
+
+// a ReaderFact for the file at path, as long as the file is now. the file is
+// opened afresh for each reader, so a retried put resends it from the start.
+func FileReaderFact(path string) (goutil.ReaderFactory, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return fileReaderFact{path, info.Size()}, nil
+}
+
+// opens the file afresh for each attempt at an upload, reading no more than
+// the n bytes the upload was sized, and signed, for
+type fileReaderFact struct {
+	path string
+	n    int64
+}
+
+func (f fileReaderFact) CreateReader() (io.ReadCloser, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, f.n), file}, nil
+}
+
+func (f fileReaderFact) Len() uint64 {
+	return uint64(f.n)
+}
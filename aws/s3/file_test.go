@@ -0,0 +1,135 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestGetToFile(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, atomic := range []bool{false, true} {
+		path := filepath.Join(dir, "got")
+		if err := s.GetToFile(ctx, o, path, DownloadOptions{Atomic: atomic}); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(path); err != nil || string(b) != "hello" {
+			t.Errorf("atomic %v: got %q, %v", atomic, b, err)
+		}
+		missing := filepath.Join(dir, "missing")
+		if err := s.GetToFile(ctx, Object{"bkt", "nope"}, missing, DownloadOptions{Atomic: atomic}); err == nil {
+			t.Errorf("atomic %v: no error for a missing object", atomic)
+		}
+		if _, err := os.Stat(missing); !os.IsNotExist(err) {
+			t.Errorf("atomic %v: failed download left a file: %v", atomic, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("left %d files, want just the download", len(entries))
+	}
+}
+
+func TestFileReaderFact(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := FileReaderFact(dir); err == nil {
+		t.Error("a directory made a ReaderFact")
+	}
+	path := filepath.Join(dir, "f")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fact, err := FileReaderFact(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file grows after it was sized
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(", and more")
+	f.Close()
+	for i := 0; i < 2; i++ {
+		r, err := fact.CreateReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != "hello" || fact.Len() != 5 {
+			t.Errorf("read %q, %v, of %d bytes", b, err, fact.Len())
+		}
+	}
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutContext(ctx, PutRequest{Object: o, ReaderFact: fact}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.GetObjectContext(ctx, GetRequest{Object: o}); err != nil || string(b) != "hello" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
+
+func TestUploadFileCutover(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		size  int
+		parts int
+	}{{100, 0}, {MinPartSize, 0}, {MinPartSize + 1, 2}} {
+		m, srv := newMultipartServer()
+		defer srv.Close()
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+		data := make([]byte, c.size)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		path := filepath.Join(dir, "f")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.UploadFile(context.Background(), Object{"bkt", "k"}, path, UploadOptions{PartSize: MinPartSize}); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.parts) != c.parts || !bytes.Equal(m.objects["/bkt/k"], data) {
+			t.Errorf("%d bytes: sent in %d parts, %d stored", c.size, len(m.parts), len(m.objects["/bkt/k"]))
+		}
+	}
+}
+
+func TestUploadFileContentType(t *testing.T) {
+	h, s := newHeaderClient(t)
+	dir := t.TempDir()
+	page := []byte("<html><body>hi</body></html>")
+	for _, c := range []struct {
+		name  string
+		sniff bool
+		want  string
+	}{
+		{"page.html", false, "text/html; charset=utf-8"},
+		{"page", false, "application/octet-stream"},
+		{"page", true, "text/html; charset=utf-8"},
+	} {
+		path := filepath.Join(dir, c.name)
+		if err := os.WriteFile(path, page, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.UploadFile(context.Background(), Object{"bkt", c.name}, path, UploadOptions{SniffContentType: c.sniff}); err != nil {
+			t.Fatal(err)
+		}
+		if got := h.last().Get("Content-Type"); got != c.want {
+			t.Errorf("%s, sniffing %v: got %q, want %q", c.name, c.sniff, got, c.want)
+		}
+	}
+}
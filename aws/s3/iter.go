@@ -0,0 +1,56 @@
+package s3
+
+import (
+	"context"
+)
+
+// pulls the keys of a listing one at a time, fetching a page only once the
+// previous one has been drained, so memory stays bounded by a single page
+type ListIterator struct {
+	s    SmartS3
+	ctx  context.Context
+	req  ListRequest
+	page []ListBucketResultContents
+	more bool
+	err  error
+}
+
+func (s SmartS3) ListIter(req ListRequest) *ListIterator {
+	return s.ListIterContext(context.Background(), req)
+}
+
+func (s SmartS3) ListIterContext(ctx context.Context, req ListRequest) *ListIterator {
+	return &ListIterator{s: s, ctx: ctx, req: req, more: true}
+}
+
+// returns the next key, or false once the listing is exhausted or has failed; check Err afterwards
+func (it *ListIterator) Next() (ListBucketResultContents, bool) {
+	for len(it.page) == 0 {
+		if !it.more || it.err != nil {
+			return ListBucketResultContents{}, false
+		}
+		it.fetch()
+	}
+	c := it.page[0]
+	it.page = it.page[1:]
+	return c, true
+}
+
+// the error that stopped iteration, if any
+func (it *ListIterator) Err() error {
+	return it.err
+}
+
+func (it *ListIterator) fetch() {
+	page, err := it.s.ListContext(it.ctx, it.req)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.page = page.Contents
+	it.more = page.IsTruncated
+	if !it.more {
+		return
+	}
+	it.err = advance(&it.req, page)
+}
@@ -0,0 +1,72 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// a listing of keys a to e, two to a page, whose third page is refused when fail is set
+func pagedLister(t *testing.T, fail bool, requests *int32) SmartS3 {
+	pages := map[string]string{"": "ab", "b": "cd", "d": "e"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		marker := r.URL.Query().Get("marker")
+		if fail && marker == "d" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code></Error>")
+			return
+		}
+		keys := pages[marker]
+		fmt.Fprintf(w, "<ListBucketResult><Name>bkt</Name><IsTruncated>%v</IsTruncated>", marker != "d")
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%c</Key></Contents>", k)
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	}))
+	t.Cleanup(srv.Close)
+	return SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+}
+
+func TestListIterPageByPage(t *testing.T) {
+	var requests int32
+	it := pagedLister(t, false, &requests).ListIter(ListRequest{Bucket: "bkt"})
+	var keys string
+	for i := 0; ; i++ {
+		c, ok := it.Next()
+		if !ok {
+			break
+		}
+		// pages are fetched only as the keys before them are used up
+		if want := int32(i/2 + 1); requests != want {
+			t.Errorf("key %d: %d pages fetched, want %d", i, requests, want)
+		}
+		keys += c.Key
+	}
+	if keys != "abcde" || it.Err() != nil {
+		t.Errorf("got %q, %v", keys, it.Err())
+	}
+}
+
+func TestListIterFailure(t *testing.T) {
+	var requests int32
+	it := pagedLister(t, true, &requests).ListIter(ListRequest{Bucket: "bkt"})
+	var keys string
+	for {
+		c, ok := it.Next()
+		if !ok {
+			break
+		}
+		keys += c.Key
+	}
+	if keys != "abcd" || it.Err() == nil {
+		t.Errorf("got %q, %v; want the first two pages and then an error", keys, it.Err())
+	}
+	if _, ok := it.Next(); ok || requests != 3 {
+		t.Errorf("the iterator carried on after failing: %d requests", requests)
+	}
+}
@@ -0,0 +1,80 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// a hash of each listing page ListAllCached has handled, by the request that
+// listed it. the zero value is empty and ready to use, and it's safe for
+// concurrent use.
+type ListCache struct {
+	mu    sync.Mutex
+	pages map[listPage][sha256.Size]byte
+}
+
+type listPage struct {
+	bucket, prefix, delimiter, marker string
+}
+
+func (c *ListCache) get(k listPage) ([sha256.Size]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.pages[k]
+	return h, ok
+}
+
+func (c *ListCache) put(k listPage, h [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pages == nil {
+		c.pages = make(map[listPage][sha256.Size]byte)
+	}
+	c.pages[k] = h
+}
+
+// lists everything req matches a page at a time, like ListAll, passing f
+// only the pages that differ from what was listed by the same request when
+// last seen through cache: a page whose keys, etags, sizes, modification
+// times and common prefixes are all the same is skipped. this saves only the
+// work f does; s3 has no conditional listing, so every page is still fetched.
+// a page is remembered once f returns without error, so one it fails on is
+// passed again next time.
+func (s SmartS3) ListAllCached(ctx context.Context, cache *ListCache, req ListRequest, f func(ListBucketResult) error) error {
+	for {
+		page, err := s.ListContext(ctx, req)
+		if err != nil {
+			return err
+		}
+		k := listPage{req.Bucket, req.Prefix, req.Delimiter, req.Marker}
+		h := pageHash(page)
+		if old, ok := cache.get(k); !ok || old != h {
+			if err := f(page); err != nil {
+				return err
+			}
+			cache.put(k, h)
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		if err := advance(&req, page); err != nil {
+			return err
+		}
+	}
+}
+
+func pageHash(page ListBucketResult) [sha256.Size]byte {
+	h := sha256.New()
+	for _, c := range page.Contents {
+		fmt.Fprintf(h, "%q %q %d %d\n", c.Key, c.ETag, c.Size, c.LastModified.UnixNano())
+	}
+	for _, p := range page.CommonPrefixes {
+		fmt.Fprintf(h, "%q\n", p)
+	}
+	fmt.Fprintf(h, "%t", page.IsTruncated)
+	var out [sha256.Size]byte
+	h.Sum(out[:0])
+	return out
+}
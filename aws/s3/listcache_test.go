@@ -0,0 +1,56 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestListAllCached(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		if err := s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", fmt.Sprint("k", i)}, Data: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cache ListCache
+	req := ListRequest{Bucket: "bkt", MaxKeys: 2}
+	seen := func() (keys []string) {
+		err := s.ListAllCached(ctx, &cache, req, func(page ListBucketResult) error {
+			for _, c := range page.Contents {
+				keys = append(keys, c.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	if got := fmt.Sprint(seen()); got != "[k0 k1 k2 k3 k4 k5]" {
+		t.Errorf("first: got %s", got)
+	}
+	if got := seen(); len(got) != 0 {
+		t.Errorf("unchanged: got %s", got)
+	}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", "k3"}, Data: []byte("changed")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(seen()); got != "[k2 k3]" {
+		t.Errorf("after a change: got %s", got)
+	}
+
+	// a page f fails on is passed again next time
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", "k0"}, Data: []byte("changed")}); err != nil {
+		t.Fatal(err)
+	}
+	boom := errors.New("boom")
+	if err := s.ListAllCached(ctx, &cache, req, func(ListBucketResult) error { return boom }); err != boom {
+		t.Errorf("got %v", err)
+	}
+	if got := fmt.Sprint(seen()); got != "[k0 k1]" {
+		t.Errorf("after a failure: got %s", got)
+	}
+}
@@ -0,0 +1,138 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// a page of a listing by the version 2 api, which pages with opaque tokens
+// rather than markers
+type ListV2Request struct {
+	Bucket    string
+	Prefix    string
+	Delimiter string
+	// 1000 when zero
+	MaxKeys int64
+	// where to resume: the previous page's NextContinuationToken
+	ContinuationToken string
+	// list only keys after this one, on the first page
+	StartAfter string
+	// as for ListRequest
+	EncodingType string
+}
+
+// one page of a version 2 listing. while IsTruncated, the next page is had by
+// repeating the request with NextContinuationToken as its ContinuationToken.
+type ListV2Result struct {
+	Name, Prefix, Delimiter, StartAfter      string
+	ContinuationToken, NextContinuationToken string
+	MaxKeys, KeyCount                        int64
+	IsTruncated                              bool
+	Contents                                 []ListBucketResultContents
+	CommonPrefixes                           []string `xml:"CommonPrefixes>Prefix"`
+}
+
+func (s SmartS3) ListV2(ctx context.Context, req ListV2Request) (ListV2Result, error) {
+	if err := ValidateBucketName(req.Bucket); err != nil {
+		return ListV2Result{}, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		return s.listV2(ctx, req)
+	}
+	v, err := s.retry(ctx, req.Bucket, print(req), f)
+	if err != nil {
+		return ListV2Result{}, err
+	}
+	return v.(ListV2Result), nil
+}
+
+func (s SmartS3) listV2(ctx context.Context, req ListV2Request) (out ListV2Result, err error) {
+	hreq, err := s.listV2Request(ctx, req)
+	if err != nil {
+		return
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("list", start, hreq, resp, err) }()
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return out, responseError(resp)
+	}
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, resp.Body); err != nil {
+		return
+	}
+	if err = xml.Unmarshal(buf.Bytes(), &out); err != nil {
+		return out, fmt.Errorf("can't parse listing of %s: %w (body starts %q)", req.Bucket, err, snippet(buf.Bytes()))
+	}
+	if req.EncodingType == "url" {
+		if err = out.urlDecode(); err != nil {
+			return out, fmt.Errorf("can't decode listing of %s: %w", req.Bucket, err)
+		}
+	}
+	return
+}
+
+// the signed request for one page of a version 2 listing, ready to send
+func (s SmartS3) listV2Request(ctx context.Context, req ListV2Request) (*http.Request, error) {
+	query := url.Values{"list-type": {"2"}}
+	if req.MaxKeys > 0 {
+		query.Add("max-keys", fmt.Sprintf("%d", req.MaxKeys))
+	} else {
+		query.Add("max-keys", "1000")
+	}
+	for k, v := range map[string]string{
+		"prefix":             req.Prefix,
+		"delimiter":          req.Delimiter,
+		"continuation-token": req.ContinuationToken,
+		"start-after":        req.StartAfter,
+		"encoding-type":      req.EncodingType,
+	} {
+		if v != "" {
+			query.Add(k, v)
+		}
+	}
+	u, resource, err := s.bucketURL(req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	now := s.now()
+	hreq, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Add("Date", format(now))
+	if err = s.authorize(hreq, resource, emptyPayload, now); err != nil {
+		return nil, err
+	}
+	return hreq, nil
+}
+
+// undoes encoding-type=url on every key-like field of a listing
+func (r *ListV2Result) urlDecode() (err error) {
+	dec := func(s *string) {
+		if err == nil {
+			*s, err = url.QueryUnescape(*s)
+		}
+	}
+	dec(&r.Prefix)
+	dec(&r.StartAfter)
+	dec(&r.Delimiter)
+	for i := range r.Contents {
+		dec(&r.Contents[i].Key)
+	}
+	for i := range r.CommonPrefixes {
+		dec(&r.CommonPrefixes[i])
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package s3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestObjectLock(t *testing.T) {
+	sub, s := newSubresourceClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutObjectLegalHold(ctx, o, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := sub.docs["/bkt/k?legal-hold"]; got != "<LegalHold><Status>ON</Status></LegalHold>" {
+		t.Errorf("legal hold: sent %s", got)
+	}
+	until := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	if err := s.PutObjectRetention(ctx, o, LockCompliance, until); err != nil {
+		t.Fatal(err)
+	}
+	if got := sub.docs["/bkt/k?retention"]; got != "<Retention><Mode>COMPLIANCE</Mode><RetainUntilDate>2030-01-02T02:04:05Z</RetainUntilDate></Retention>" {
+		t.Errorf("retention: sent %s", got)
+	}
+	if err := s.PutObjectRetention(ctx, o, "FOREVER", until); err == nil {
+		t.Error("no error for an unknown mode")
+	}
+	if err := s.PutObjectRetention(ctx, o, LockGovernance, time.Time{}); err == nil {
+		t.Error("no error without a retain-until date")
+	}
+}
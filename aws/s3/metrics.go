@@ -0,0 +1,31 @@
+package s3
+
+import (
+	"net/http"
+	"time"
+)
+
+// receives a measurement of each request made by get, put, delete, head and
+// list, for wiring up to prometheus, statsd and the like
+type MetricsRecorder interface {
+	// op is "get", "put", "delete", "head" or "list". bytes is the length of
+	// the body sent for a put, and otherwise of the body received as the
+	// response declared it, -1 if it didn't. err is the attempt's outcome.
+	RecordRequest(op string, duration time.Duration, bytes int64, err error)
+}
+
+// reports an attempt at op that began at start to s.Metrics
+func (s SmartS3) record(op string, start time.Time, hreq *http.Request, resp *http.Response, err error) {
+	if s.Metrics == nil {
+		return
+	}
+	var n int64
+	switch {
+	case hreq.Method == "PUT":
+		n = hreq.ContentLength
+	case hreq.Method == "HEAD":
+	case resp != nil:
+		n = resp.ContentLength
+	}
+	s.Metrics.RecordRequest(op, time.Since(start), n, err)
+}
@@ -0,0 +1,67 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorded struct {
+	op    string
+	bytes int64
+	err   bool
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	got  []recorded
+	took time.Duration
+}
+
+func (r *recorder) RecordRequest(op string, d time.Duration, bytes int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, recorded{op, bytes, err != nil})
+	r.took += d
+}
+
+func TestMetrics(t *testing.T) {
+	s := newMockClient(t)
+	m := new(recorder)
+	s.Metrics = m
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: o}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.HeadContext(ctx, HeadRequest{Object: o}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ListAllContext(ctx, ListRequest{Bucket: "bkt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteContext(ctx, DeleteRequest{Object: o}); err != nil {
+		t.Fatal(err)
+	}
+	s.GetObjectContext(ctx, GetRequest{Object: o})
+	if len(m.got) != 6 {
+		t.Fatalf("got %v", m.got)
+	}
+	// the listing's size is whatever its xml came to
+	if m.got[3].bytes <= 0 {
+		t.Errorf("list of %d bytes", m.got[3].bytes)
+	}
+	m.got[3].bytes = 0
+	want := []recorded{{"put", 5, false}, {"get", 5, false}, {"head", 0, false}, {"list", 0, false}, {"delete", 0, false}, {"get", m.got[5].bytes, true}}
+	if fmt.Sprint(m.got) != fmt.Sprint(want) {
+		t.Errorf("got %v\nwant %v", m.got, want)
+	}
+	if m.took <= 0 {
+		t.Error("no time taken")
+	}
+}
@@ -0,0 +1,447 @@
+// an in-memory s3 over httptest, for testing code that uses package s3
+// without the real thing. it speaks enough of the rest api for objects and
+// listings: put, copy, get (ranges and conditions included), head, delete,
+// multi-object delete, and both versions of list. requests aren't authenticated.
+package mock
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	xmlns      = "http://s3.amazonaws.com/doc/2006-03-01/"
+	timeFormat = "2006-01-02T15:04:05.000Z"
+	metaPrefix = "X-Amz-Meta-"
+)
+
+// an s3 holding the buckets it was created with, and any made since. give its
+// URL to a client as the endpoint; being an ip address, it's addressed path style.
+type Server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string]*object
+}
+
+type object struct {
+	data        []byte
+	contentType string
+	etag        string
+	modified    time.Time
+	// other headers stored with the object, such as x-amz-meta-*
+	header http.Header
+}
+
+// starts a server with the given buckets; Close it when done
+func NewServer(buckets ...string) *Server {
+	s := &Server{buckets: make(map[string]map[string]*object)}
+	for _, b := range buckets {
+		s.CreateBucket(b)
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[name] == nil {
+		s.buckets[name] = make(map[string]*object)
+	}
+}
+
+// the body stored at bucket and key, if there is one
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return o.data, true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("X-Amz-Request-Id", "mock")
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case bucket == "" && r.Method == "GET":
+		s.listBuckets(w)
+	case bucket == "":
+		fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method on the service")
+	case key == "":
+		s.serveBucket(w, r, bucket)
+	default:
+		s.serveObject(w, r, bucket, key)
+	}
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter) {
+	type bucket struct {
+		Name         string
+		CreationDate string
+	}
+	var out struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}
+	out.Xmlns = xmlns
+	for name := range s.buckets {
+		out.Buckets = append(out.Buckets, bucket{name, time.Unix(0, 0).UTC().Format(timeFormat)})
+	}
+	sort.Slice(out.Buckets, func(i, j int) bool { return out.Buckets[i].Name < out.Buckets[j].Name })
+	reply(w, out)
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, name string) {
+	q := r.URL.Query()
+	objects, exists := s.buckets[name]
+	switch {
+	case r.Method == "PUT" && len(q) == 0:
+		if exists {
+			fail(w, http.StatusConflict, "BucketAlreadyOwnedByYou", "you already own "+name)
+			return
+		}
+		s.buckets[name] = make(map[string]*object)
+	case !exists:
+		fail(w, http.StatusNotFound, "NoSuchBucket", "no bucket "+name)
+	case r.Method == "DELETE" && len(q) == 0:
+		if len(objects) > 0 {
+			fail(w, http.StatusConflict, "BucketNotEmpty", name+" isn't empty")
+			return
+		}
+		delete(s.buckets, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" && q.Has("location"):
+		reply(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Xmlns   string   `xml:"xmlns,attr"`
+		}{Xmlns: xmlns})
+	case r.Method == "GET" && onlyListing(q):
+		list(w, name, objects, q)
+	case r.Method == "POST" && q.Has("delete") && len(q) == 1:
+		deleteObjects(w, r, objects)
+	default:
+		fail(w, http.StatusNotImplemented, "NotImplemented", "the mock doesn't support this bucket request")
+	}
+}
+
+// whether q holds nothing but listing parameters, as opposed to a subresource
+func onlyListing(q url.Values) bool {
+	for k := range q {
+		switch k {
+		case "prefix", "delimiter", "marker", "max-keys", "encoding-type",
+			"list-type", "continuation-token", "start-after", "fetch-owner":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type contents struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type listResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Marker                *string `xml:",omitempty"`
+	StartAfter            string  `xml:",omitempty"`
+	ContinuationToken     string  `xml:",omitempty"`
+	NextContinuationToken string  `xml:",omitempty"`
+	NextMarker            string  `xml:",omitempty"`
+	KeyCount              *int    `xml:",omitempty"`
+	MaxKeys               int
+	Delimiter             string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	IsTruncated           bool
+	Contents              []contents
+	CommonPrefixes        []string `xml:"CommonPrefixes>Prefix"`
+}
+
+// answers either version of list objects, as q asks
+func list(w http.ResponseWriter, bucket string, objects map[string]*object, q url.Values) {
+	limit := 1000
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			fail(w, http.StatusBadRequest, "InvalidArgument", "bad max-keys "+v)
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	out := listResult{Xmlns: xmlns, Name: bucket, Prefix: prefix, MaxKeys: limit, Delimiter: delimiter}
+	v2 := q.Get("list-type") == "2"
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if t := q.Get("continuation-token"); t != "" {
+			k, err := base64.RawURLEncoding.DecodeString(t)
+			if err != nil {
+				fail(w, http.StatusBadRequest, "InvalidArgument", "bad continuation token")
+				return
+			}
+			after = string(k)
+		}
+		out.StartAfter, out.ContinuationToken = q.Get("start-after"), q.Get("continuation-token")
+	} else {
+		out.Marker = &after
+	}
+	// resuming after a common prefix skips everything it rolled up
+	skip := delimiter != "" && strings.HasSuffix(after, delimiter)
+	keys := make([]string, 0, len(objects))
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > after && !(skip && strings.HasPrefix(k, after)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var last string
+	for _, k := range keys {
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				cp := k[:len(prefix)+i+len(delimiter)]
+				if n := len(out.CommonPrefixes); n > 0 && out.CommonPrefixes[n-1] == cp {
+					continue
+				}
+				if len(out.Contents)+len(out.CommonPrefixes) == limit {
+					out.IsTruncated = true
+					break
+				}
+				out.CommonPrefixes = append(out.CommonPrefixes, cp)
+				last = cp
+				continue
+			}
+		}
+		if len(out.Contents)+len(out.CommonPrefixes) == limit {
+			out.IsTruncated = true
+			break
+		}
+		o := objects[k]
+		out.Contents = append(out.Contents, contents{k, o.modified.Format(timeFormat), o.etag, int64(len(o.data)), "STANDARD"})
+		last = k
+	}
+	if out.IsTruncated {
+		if v2 {
+			out.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else if delimiter != "" {
+			out.NextMarker = last
+		}
+	}
+	if v2 {
+		n := len(out.Contents) + len(out.CommonPrefixes)
+		out.KeyCount = &n
+	}
+	if q.Get("encoding-type") == "url" {
+		out.EncodingType = "url"
+		esc := func(s string) string { return strings.ReplaceAll(url.QueryEscape(s), "+", "%20") }
+		out.Prefix, out.Delimiter, out.StartAfter, out.NextMarker = esc(out.Prefix), esc(out.Delimiter), esc(out.StartAfter), esc(out.NextMarker)
+		if out.Marker != nil {
+			m := esc(*out.Marker)
+			out.Marker = &m
+		}
+		for i := range out.Contents {
+			out.Contents[i].Key = esc(out.Contents[i].Key)
+		}
+		for i := range out.CommonPrefixes {
+			out.CommonPrefixes[i] = esc(out.CommonPrefixes[i])
+		}
+	}
+	reply(w, out)
+}
+
+// answers a multi-object delete, which always succeeds for every key
+func deleteObjects(w http.ResponseWriter, r *http.Request, objects map[string]*object) {
+	var req struct {
+		Quiet   bool
+		Objects []struct{ Key string } `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		fail(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	type deleted struct{ Key string }
+	var out struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		Xmlns   string    `xml:"xmlns,attr"`
+		Deleted []deleted `xml:"Deleted"`
+	}
+	out.Xmlns = xmlns
+	for _, o := range req.Objects {
+		delete(objects, o.Key)
+		if !req.Quiet {
+			out.Deleted = append(out.Deleted, deleted{o.Key})
+		}
+	}
+	reply(w, out)
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		fail(w, http.StatusNotFound, "NoSuchBucket", "no bucket "+bucket)
+		return
+	}
+	if q := r.URL.Query(); len(q) > 0 {
+		fail(w, http.StatusNotImplemented, "NotImplemented", "the mock doesn't support ?"+r.URL.RawQuery)
+		return
+	}
+	switch r.Method {
+	case "PUT":
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			s.copyObject(w, r, objects, key, src)
+			return
+		}
+		s.putObject(w, r, objects, key)
+	case "GET", "HEAD":
+		o, ok := objects[key]
+		if !ok {
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fail(w, http.StatusNotFound, "NoSuchKey", "no key "+key)
+			return
+		}
+		h := w.Header()
+		for k, v := range o.header {
+			h[k] = v
+		}
+		h.Set("Content-Type", o.contentType)
+		h.Set("ETag", o.etag)
+		h.Set("Accept-Ranges", "bytes")
+		// handles ranges, If-None-Match, If-Modified-Since and HEAD
+		http.ServeContent(w, r, "", o.modified, bytes.NewReader(o.data))
+	case "DELETE":
+		if o, ok := objects[key]; ok && r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != o.etag {
+			fail(w, http.StatusPreconditionFailed, "PreconditionFailed", "etag doesn't match")
+			return
+		}
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" isn't supported on objects")
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, objects map[string]*object, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	sum := md5.Sum(data)
+	if want := r.Header.Get("Content-Md5"); want != "" && want != base64.StdEncoding.EncodeToString(sum[:]) {
+		fail(w, http.StatusBadRequest, "BadDigest", "the Content-MD5 you specified did not match what we received")
+		return
+	}
+	o := &object{
+		data:        data,
+		contentType: r.Header.Get("Content-Type"),
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		modified:    time.Now().UTC().Truncate(time.Second),
+		header:      storedHeaders(r.Header),
+	}
+	if o.contentType == "" {
+		o.contentType = "binary/octet-stream"
+	}
+	objects[key] = o
+	w.Header().Set("ETag", o.etag)
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, objects map[string]*object, key, source string) {
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "InvalidArgument", "bad copy source")
+		return
+	}
+	sb, sk, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	src, ok := s.buckets[sb][sk]
+	if !ok {
+		fail(w, http.StatusNotFound, "NoSuchKey", "no key "+sk)
+		return
+	}
+	if m := r.Header.Get("X-Amz-Copy-Source-If-Match"); m != "" && m != src.etag {
+		fail(w, http.StatusPreconditionFailed, "PreconditionFailed", "etag doesn't match")
+		return
+	}
+	if m := r.Header.Get("X-Amz-Copy-Source-If-None-Match"); m != "" && m == src.etag {
+		fail(w, http.StatusPreconditionFailed, "PreconditionFailed", "etag matches")
+		return
+	}
+	o := *src
+	o.modified = time.Now().UTC().Truncate(time.Second)
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		o.contentType = r.Header.Get("Content-Type")
+		o.header = storedHeaders(r.Header)
+	}
+	objects[key] = &o
+	reply(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string
+		ETag         string
+	}{LastModified: o.modified.Format(timeFormat), ETag: o.etag})
+}
+
+// the headers of a put that s3 keeps with the object and serves back
+func storedHeaders(h http.Header) http.Header {
+	out := make(http.Header)
+	for k, v := range h {
+		switch k = http.CanonicalHeaderKey(k); {
+		case strings.HasPrefix(k, metaPrefix),
+			k == "Cache-Control", k == "Content-Disposition", k == "Content-Encoding", k == "Expires",
+			k == "X-Amz-Storage-Class", k == "X-Amz-Website-Redirect-Location":
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func reply(w http.ResponseWriter, doc interface{}) {
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		fail(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	w.Write(body)
+}
+
+func fail(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message><RequestId>mock</RequestId></Error>", xml.Header, code, escape(message))
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,216 @@
+package mock_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+	"github.com/xoba/goutil/aws/s3"
+	"github.com/xoba/goutil/aws/s3/mock"
+)
+
+// clients of a fresh mock holding bucket "bkt", signing both ways
+func newClients(t *testing.T) (*mock.Server, []s3.SmartS3) {
+	srv := mock.NewServer("bkt")
+	t.Cleanup(srv.Close)
+	auth := aws.Auth{AccessKey: "a", SecretKey: "b", Region: "us-east-1"}
+	return srv, []s3.SmartS3{
+		{Auth: auth, Endpoint: srv.URL},
+		{Auth: auth, Endpoint: srv.URL, SigV4: true},
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// puts n keys spread over three "folders", returning them sorted
+func putKeys(t *testing.T, c s3.SmartS3, n int) []string {
+	var keys []string
+	for i := 0; i < n; i++ {
+		k := fmt.Sprintf("d%d/k %02d", i%3, i)
+		if err := c.PutObjectContext(context.Background(), s3.PutObjectRequest{Object: s3.Object{Bucket: "bkt", Key: k}, Data: []byte(k)}); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestETags(t *testing.T) {
+	_, clients := newClients(t)
+	ctx := context.Background()
+	for _, c := range clients {
+		data := []byte("some data")
+		o := s3.Object{Bucket: "bkt", Key: "k"}
+		req := s3.PutObjectRequest{Object: o, Data: data, ContentMD5: true}
+		req.Metadata = map[string]string{"x": "y"}
+		res, err := c.PutObjectWithResult(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ETag != etag(data) {
+			t.Errorf("put: got etag %s, want %s", res.ETag, etag(data))
+		}
+		info, err := c.HeadContext(ctx, s3.HeadRequest{Object: o})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ETag != etag(data) || info.ContentLength != int64(len(data)) || info.Metadata["x"] != "y" {
+			t.Errorf("head: got %+v", info)
+		}
+		l, err := c.ListAllContext(ctx, s3.ListRequest{Bucket: "bkt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Contents) != 1 || l.Contents[0].ETag != etag(data) || l.Contents[0].Size != len(data) {
+			t.Errorf("list: got %+v", l.Contents)
+		}
+		cr, err := c.Copy(ctx, o, s3.Object{Bucket: "bkt", Key: "copy"}, s3.CopyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cr.ETag != etag(data) {
+			t.Errorf("copy: got etag %s, want %s", cr.ETag, etag(data))
+		}
+		if err := c.DeleteContext(ctx, s3.DeleteRequest{Object: s3.Object{Bucket: "bkt", Key: "copy"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	_, clients := newClients(t)
+	ctx := context.Background()
+	keys := putKeys(t, clients[0], 25)
+	for _, c := range clients {
+		all, err := c.ListAllContext(ctx, s3.ListRequest{Bucket: "bkt", MaxKeys: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all.Contents) != len(keys) {
+			t.Fatalf("v1: listed %d keys, want %d", len(all.Contents), len(keys))
+		}
+		for i, x := range all.Contents {
+			if x.Key != keys[i] {
+				t.Errorf("v1: key %d is %q, want %q", i, x.Key, keys[i])
+			}
+		}
+
+		var v2 []string
+		req := s3.ListV2Request{Bucket: "bkt", MaxKeys: 4}
+		for pages := 1; ; pages++ {
+			page, err := c.ListV2(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.KeyCount != int64(len(page.Contents)) {
+				t.Errorf("v2: KeyCount %d for %d keys", page.KeyCount, len(page.Contents))
+			}
+			for _, x := range page.Contents {
+				v2 = append(v2, x.Key)
+			}
+			if !page.IsTruncated {
+				if pages != 7 {
+					t.Errorf("v2: %d pages, want 7", pages)
+				}
+				break
+			}
+			req.ContinuationToken = page.NextContinuationToken
+		}
+		if fmt.Sprint(v2) != fmt.Sprint(keys) {
+			t.Errorf("v2: got %q, want %q", v2, keys)
+		}
+
+		page, err := c.ListV2(ctx, s3.ListV2Request{Bucket: "bkt", StartAfter: keys[20]})
+		if err != nil || len(page.Contents) != 4 || page.Contents[0].Key != keys[21] {
+			t.Errorf("v2 start after: %v, %+v", err, page.Contents)
+		}
+
+		n := 0
+		it := c.ListIterContext(ctx, s3.ListRequest{Bucket: "bkt", MaxKeys: 3, Prefix: "d1/", EncodingType: "url"})
+		for {
+			x, ok := it.Next()
+			if !ok {
+				break
+			}
+			if x.Key[:3] != "d1/" {
+				t.Errorf("iterator: %q outside the prefix", x.Key)
+			}
+			n++
+		}
+		if it.Err() != nil || n != 8 {
+			t.Errorf("iterator: %v after %d keys, want 8", it.Err(), n)
+		}
+	}
+}
+
+func TestListDelimiter(t *testing.T) {
+	_, clients := newClients(t)
+	ctx := context.Background()
+	putKeys(t, clients[0], 9)
+	if err := clients[0].PutObjectContext(ctx, s3.PutObjectRequest{Object: s3.Object{Bucket: "bkt", Key: "top"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		all, err := c.ListAllContext(ctx, s3.ListRequest{Bucket: "bkt", MaxKeys: 1, Delimiter: "/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(all.CommonPrefixes) != "[d0/ d1/ d2/]" || len(all.Contents) != 1 || all.Contents[0].Key != "top" {
+			t.Errorf("got prefixes %q and %+v", all.CommonPrefixes, all.Contents)
+		}
+		page, err := c.ListV2(ctx, s3.ListV2Request{Bucket: "bkt", Delimiter: "/", Prefix: "d2/"})
+		if err != nil || len(page.Contents) != 3 || len(page.CommonPrefixes) != 0 {
+			t.Errorf("v2: %v, %+v", err, page)
+		}
+	}
+}
+
+func TestDeleteMulti(t *testing.T) {
+	srv, clients := newClients(t)
+	ctx := context.Background()
+	for _, c := range clients {
+		keys := putKeys(t, c, 6)
+		res, err := c.DeleteMulti(ctx, "bkt", append(keys[:4:4], "never there"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Deleted) != 5 || len(res.Errors) != 0 {
+			t.Errorf("got %+v", res)
+		}
+		for i, k := range keys {
+			if _, ok := srv.Object("bkt", k); ok != (i >= 4) {
+				t.Errorf("%q stored: %v", k, ok)
+			}
+		}
+		if d, f, err := c.DeleteAll(ctx, "bkt", "", false); err != nil || d != 2 || f != 0 {
+			t.Errorf("delete all: %d deleted, %d failed, %v", d, f, err)
+		}
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	_, clients := newClients(t)
+	ctx := context.Background()
+	o := s3.Object{Bucket: "bkt", Key: "k"}
+	for _, c := range clients {
+		if err := c.PutObjectContext(ctx, s3.PutObjectRequest{Object: o, Data: []byte("0123456789")}); err != nil {
+			t.Fatal(err)
+		}
+		b, err := c.GetRange(ctx, o, 2, 4)
+		if err != nil || string(b) != "234" {
+			t.Errorf("got %q, %v", b, err)
+		}
+		rs, err := c.GetRanges(ctx, o, []s3.ByteRange{{Start: 0, End: 1}, {Start: 8, End: 9}})
+		if err != nil || len(rs) != 2 || string(rs[0]) != "01" || string(rs[1]) != "89" {
+			t.Errorf("got %q, %v", rs, err)
+		}
+	}
+}
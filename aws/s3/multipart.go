@@ -0,0 +1,281 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+const (
+	// smallest part s3 accepts, other than the last one
+	MinPartSize = 5 << 20
+	// defaults for UploadLarge
+	DefaultPartSize    = 8 << 20
+	DefaultConcurrency = 5
+)
+
+// tuning for UploadLarge; zero values pick the defaults
+type UploadOptions struct {
+	ContentType string
+	// as for PutOptions
+	SniffContentType bool
+	PartSize         int64
+	// how many parts are in flight at once
+	Concurrency int
+}
+
+// the part size and concurrency to use, with defaults and limits applied
+func (o UploadOptions) sizes() (partSize int64, concurrency int) {
+	partSize = o.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < MinPartSize {
+		partSize = MinPartSize
+	}
+	concurrency = o.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return
+}
+
+// an upload in progress, identified by the id s3 handed out when it began
+type MultipartUpload struct {
+	Object   Object
+	UploadID string
+}
+
+// a part that has been sent, as needed to complete the upload
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type initiateMultipartUploadResult struct {
+	Bucket, Key, UploadId string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []CompletedPart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	Location, Bucket, Key, ETag string
+}
+
+// begins a multipart upload of o; finish it with CompleteMultipartUpload, or AbortMultipartUpload on failure
+func (s SmartS3) InitiateMultipartUpload(ctx context.Context, o Object, contentType string) (MultipartUpload, error) {
+	if err := checkObject(o); err != nil {
+		return MultipartUpload{}, err
+	}
+	if contentType == "" {
+		contentType = mimeType(o.Key)
+	}
+	u, resource, err := s.subresourceURL(o, url.Values{"uploads": {""}})
+	if err != nil {
+		return MultipartUpload{}, err
+	}
+	var out initiateMultipartUploadResult
+	h := http.Header{"Content-Type": {contentType}}
+	if err := s.doXML(ctx, "POST", u, resource, h, nil, &out); err != nil {
+		return MultipartUpload{}, err
+	}
+	if out.UploadId == "" {
+		return MultipartUpload{}, fmt.Errorf("no upload id for %s/%s", o.Bucket, o.Key)
+	}
+	return MultipartUpload{Object: o, UploadID: out.UploadId}, nil
+}
+
+// sends one part, numbered from 1; every part but the last must be at least MinPartSize
+func (s SmartS3) UploadPart(ctx context.Context, m MultipartUpload, partNumber int, data []byte) (CompletedPart, error) {
+	if partNumber < 1 || partNumber > 10000 {
+		return CompletedPart{}, fmt.Errorf("part number %d out of range 1-10000", partNumber)
+	}
+	q := url.Values{"partNumber": {fmt.Sprint(partNumber)}, "uploadId": {m.UploadID}}
+	u, resource, err := s.subresourceURL(m.Object, q)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		resp, err := s.do(ctx, "PUT", u, resource, nil, data)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return CompletedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")}, nil
+	}
+	v, err := s.retry(ctx, m.Object.Bucket, fmt.Sprintf("part %d of %s", partNumber, print(m)), f)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+	return v.(CompletedPart), nil
+}
+
+// assembles the sent parts, in part number order, into the final object
+func (s SmartS3) CompleteMultipartUpload(ctx context.Context, m MultipartUpload, parts []CompletedPart) (PutResult, error) {
+	if len(parts) == 0 {
+		return PutResult{}, fmt.Errorf("no parts to complete %s/%s", m.Object.Bucket, m.Object.Key)
+	}
+	sorted := append([]CompletedPart(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PartNumber < sorted[j].PartNumber })
+	body, err := xml.Marshal(completeMultipartUpload{Parts: sorted})
+	if err != nil {
+		return PutResult{}, err
+	}
+	u, resource, err := s.subresourceURL(m.Object, url.Values{"uploadId": {m.UploadID}})
+	if err != nil {
+		return PutResult{}, err
+	}
+	var out completeMultipartUploadResult
+	h := http.Header{"Content-Type": {"application/xml"}}
+	if err := s.doXML(ctx, "POST", u, resource, h, body, &out); err != nil {
+		return PutResult{}, err
+	}
+	return PutResult{ETag: out.ETag}, nil
+}
+
+// discards an upload and any parts already sent
+func (s SmartS3) AbortMultipartUpload(ctx context.Context, m MultipartUpload) error {
+	u, resource, err := s.subresourceURL(m.Object, url.Values{"uploadId": {m.UploadID}})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, "DELETE", u, resource, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploads size bytes of r as a multipart upload, sending several parts at once.
+// each part is retried per s.Strat; if one still fails the whole upload is aborted.
+func (s SmartS3) UploadLarge(ctx context.Context, o Object, r io.ReaderAt, size int64, opts UploadOptions) (PutResult, error) {
+	partSize, concurrency := opts.sizes()
+	count := int((size + partSize - 1) / partSize)
+	if count == 0 {
+		count = 1
+	}
+	if count > 10000 {
+		return PutResult{}, fmt.Errorf("%d bytes needs %d parts of %d, more than s3 allows", size, count, partSize)
+	}
+	if opts.ContentType == "" && opts.SniffContentType {
+		head := make([]byte, sniffLen)
+		n, err := r.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return PutResult{}, err
+		}
+		opts.ContentType = sniffType(o.Key, head[:n])
+	}
+	m, err := s.InitiateMultipartUpload(ctx, o, opts.ContentType)
+	if err != nil {
+		return PutResult{}, err
+	}
+	p := newPool(ctx, concurrency)
+	parts := make([]CompletedPart, count)
+	for i := 0; i < count && p.ctx.Err() == nil; i++ {
+		i := i
+		p.Go(func(ctx context.Context) error {
+			off := int64(i) * partSize
+			n := partSize
+			if off+n > size {
+				n = size - off
+			}
+			buf := make([]byte, n)
+			if k, err := r.ReadAt(buf, off); k < len(buf) {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			part, err := s.UploadPart(ctx, m, i+1, buf)
+			if err != nil {
+				return err
+			}
+			parts[i] = part
+			return nil
+		})
+	}
+	if err := p.Wait(); err != nil {
+		// abort with a fresh context, since ours may be what was cancelled
+		if aerr := s.AbortMultipartUpload(context.Background(), m); aerr != nil {
+			return PutResult{}, fmt.Errorf("%w (and abort failed: %v)", err, aerr)
+		}
+		return PutResult{}, err
+	}
+	return s.CompleteMultipartUpload(ctx, m, parts)
+}
+
+// uploads everything r yields until eof, without knowing its length up front:
+// in one put if it all fits in a part, and otherwise in parts, holding at most
+// Concurrency+1 of them in memory: those being sent, and the next being read
+func (s SmartS3) UploadStream(ctx context.Context, o Object, r io.Reader, opts UploadOptions) (PutResult, error) {
+	partSize, concurrency := opts.sizes()
+	first, err := readPart(r, partSize)
+	if err != nil {
+		return PutResult{}, err
+	}
+	if opts.ContentType == "" && opts.SniffContentType {
+		opts.ContentType = sniffType(o.Key, first)
+	}
+	if int64(len(first)) < partSize {
+		return s.PutObjectWithResult(ctx, PutObjectRequest{Object: o, ContentType: opts.ContentType, Data: first})
+	}
+	m, err := s.InitiateMultipartUpload(ctx, o, opts.ContentType)
+	if err != nil {
+		return PutResult{}, err
+	}
+	p := newPool(ctx, concurrency)
+	var (
+		mu    sync.Mutex
+		parts []CompletedPart
+	)
+	for n, buf := 1, first; len(buf) > 0 && p.ctx.Err() == nil; n++ {
+		if n > 10000 {
+			p.fail(fmt.Errorf("stream needs more than 10000 parts of %d bytes", partSize))
+			break
+		}
+		num, data := n, buf
+		p.Go(func(ctx context.Context) error {
+			part, err := s.UploadPart(ctx, m, num, data)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			parts = append(parts, part)
+			mu.Unlock()
+			return nil
+		})
+		if int64(len(buf)) < partSize {
+			break
+		}
+		if buf, err = readPart(r, partSize); err != nil {
+			p.fail(err)
+			break
+		}
+	}
+	if err := p.Wait(); err != nil {
+		// abort with a fresh context, since ours may be what was cancelled
+		if aerr := s.AbortMultipartUpload(context.Background(), m); aerr != nil {
+			return PutResult{}, fmt.Errorf("%w (and abort failed: %v)", err, aerr)
+		}
+		return PutResult{}, err
+	}
+	return s.CompleteMultipartUpload(ctx, m, parts)
+}
+
+// up to n bytes from r, fewer only at eof
+func readPart(r io.Reader, n int64) ([]byte, error) {
+	buf := make([]byte, n)
+	k, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:k], err
+}
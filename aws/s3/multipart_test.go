@@ -0,0 +1,147 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil"
+	"github.com/xoba/goutil/aws"
+)
+
+// a server assembling multipart uploads, one at a time, into objects
+type multipartServer struct {
+	mu        sync.Mutex
+	parts     map[int][]byte
+	objects   map[string][]byte
+	aborts    int
+	failAbort bool
+	// a part number to refuse, when not zero
+	failPart int
+}
+
+func newMultipartServer() (*multipartServer, *httptest.Server) {
+	m := &multipartServer{objects: make(map[string][]byte)}
+	return m, httptest.NewServer(m)
+}
+
+func (m *multipartServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := r.URL.Query()
+	switch {
+	case r.Method == "POST" && r.URL.RawQuery == "uploads":
+		m.parts = make(map[int][]byte)
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == "PUT" && q.Get("partNumber") != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if n == m.failPart {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code></Error>")
+			return
+		}
+		m.parts[n] = b
+		w.Header().Set("ETag", fmt.Sprintf(`"p%d"`, n))
+	case r.Method == "PUT":
+		m.objects[r.URL.Path] = b
+		w.Header().Set("ETag", `"whole"`)
+	case r.Method == "POST":
+		var nums []int
+		for n := range m.parts {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		var all []byte
+		for _, n := range nums {
+			all = append(all, m.parts[n]...)
+		}
+		m.objects[r.URL.Path] = all
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"done"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == "DELETE":
+		m.aborts++
+		if m.failAbort {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code></Error>")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestUploadLarge(t *testing.T) {
+	m, srv := newMultipartServer()
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, PathStyle: true}
+	data := make([]byte, 3*MinPartSize+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	opts := UploadOptions{PartSize: MinPartSize, Concurrency: 2}
+	if _, err := s.UploadLarge(context.Background(), Object{"bkt", "k"}, bytes.NewReader(data), int64(len(data)), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.parts) != 4 || !bytes.Equal(m.objects["/bkt/k"], data) {
+		t.Fatalf("%d parts, %d bytes", len(m.parts), len(m.objects["/bkt/k"]))
+	}
+	if m.aborts != 0 {
+		t.Errorf("%d aborts", m.aborts)
+	}
+}
+
+func TestUploadLargeAbortsOnFailure(t *testing.T) {
+	m, srv := newMultipartServer()
+	defer srv.Close()
+	m.failPart = 2
+	s := SmartS3{
+		Auth:     aws.Auth{AccessKey: "a", SecretKey: "b"},
+		Endpoint: srv.URL,
+		Strat:    goutil.RetryBackoffStrat{Delay: time.Millisecond, Retries: 1},
+	}
+	data := make([]byte, 3*MinPartSize)
+	opts := UploadOptions{PartSize: MinPartSize, Concurrency: 2}
+	if _, err := s.UploadLarge(context.Background(), Object{"bkt", "k"}, bytes.NewReader(data), int64(len(data)), opts); err == nil {
+		t.Fatal("upload succeeded")
+	}
+	if m.aborts != 1 {
+		t.Errorf("%d aborts, want 1", m.aborts)
+	}
+	if _, ok := m.objects["/bkt/k"]; ok {
+		t.Error("upload completed")
+	}
+}
+
+func TestUploadStream(t *testing.T) {
+	for _, n := range []int{123, 2 * MinPartSize, 3*MinPartSize + 123} {
+		m, srv := newMultipartServer()
+		defer srv.Close()
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, PathStyle: true}
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		// hides Len and Seek, so nothing tells the length ahead
+		r := struct{ io.Reader }{bytes.NewReader(data)}
+		opts := UploadOptions{PartSize: MinPartSize, Concurrency: 2}
+		if _, err := s.UploadStream(context.Background(), Object{"bkt", "k"}, r, opts); err != nil {
+			t.Fatal(err)
+		}
+		if want := (n + MinPartSize - 1) / MinPartSize; n > MinPartSize && len(m.parts) != want {
+			t.Errorf("%d bytes sent in %d parts, want %d", n, len(m.parts), want)
+		}
+		if n < MinPartSize && len(m.parts) != 0 {
+			t.Errorf("%d bytes sent in %d parts, want one put", n, len(m.parts))
+		}
+		if !bytes.Equal(m.objects["/bkt/k"], data) {
+			t.Errorf("%d bytes sent, %d stored", n, len(m.objects["/bkt/k"]))
+		}
+	}
+}
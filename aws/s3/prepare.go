@@ -0,0 +1,37 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+)
+
+// the Prepare methods build and sign the request the like-named operation
+// would send, and return it unsent, to inspect or to send some other way.
+// the date, and so the signature, is fixed once prepared.
+
+func (s SmartS3) PrepareGet(ctx context.Context, req GetRequest) (*http.Request, error) {
+	if err := checkObject(req.Object); err != nil {
+		return nil, err
+	}
+	return s.getRequest(ctx, req)
+}
+
+// prepares a PutObject; the body can be read again through GetBody
+func (s SmartS3) PreparePut(ctx context.Context, req PutObjectRequest) (*http.Request, error) {
+	if err := checkObject(req.Object); err != nil {
+		return nil, err
+	}
+	return s.putObjectRequest(ctx, req)
+}
+
+func (s SmartS3) PrepareDelete(ctx context.Context, req DeleteRequest) (*http.Request, error) {
+	if err := checkObject(req.Object); err != nil {
+		return nil, err
+	}
+	return s.delRequest(ctx, req)
+}
+
+// prepares the request for one page of a listing
+func (s SmartS3) PrepareList(ctx context.Context, req ListRequest) (*http.Request, error) {
+	return s.listRequest(ctx, req)
+}
@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestPreparePut(t *testing.T) {
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: "http://127.0.0.1"}
+	s.Now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	r, err := s.PreparePut(context.Background(), PutObjectRequest{Object: Object{"bkt", "k"}, ContentType: "text/plain", Data: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Method != "PUT" || r.URL.String() != "http://127.0.0.1/bkt/k" {
+		t.Errorf("%s %s", r.Method, r.URL)
+	}
+	if got := r.Header.Get("Date"); got != "Thu, 02 Jan 2020 03:04:05 +0000" {
+		t.Errorf("date %q", got)
+	}
+	if got := r.Header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("content type %q", got)
+	}
+	sig, err := sign(s.Auth, "PUT\n\ntext/plain\nThu, 02 Jan 2020 03:04:05 +0000\n/bkt/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header.Get("Authorization"); got != "AWS a:"+sig {
+		t.Errorf("got %q, want %q", got, "AWS a:"+sig)
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(body); string(b) != "hello" {
+		t.Errorf("body %q", b)
+	}
+}
+
+func TestPrepared(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	send := func(r *http.Request, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			t.Errorf("%s %s: %s", r.Method, r.URL, resp.Status)
+		}
+	}
+	o := Object{"bkt", "k"}
+	send(s.PreparePut(ctx, PutObjectRequest{Object: o, Data: []byte("x")}))
+	send(s.PrepareGet(ctx, GetRequest{Object: o}))
+	send(s.PrepareList(ctx, ListRequest{Bucket: "bkt"}))
+	send(s.PrepareDelete(ctx, DeleteRequest{Object: o}))
+	if _, err := s.HeadContext(ctx, HeadRequest{Object: o}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v after the prepared delete, want ErrNotFound", err)
+	}
+}
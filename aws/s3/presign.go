@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+var (
+	// ValidatePresigned found the url past its expiry
+	ErrPresignExpired = errors.New("presigned url expired")
+	// ValidatePresigned found the url wasn't signed with these credentials, or
+	// was changed since
+	ErrPresignInvalid = errors.New("presigned url invalid")
+)
+
+// a url anyone can GET o from until expires has elapsed, via v2 query-string signing
+func (s SmartS3) PresignGet(o Object, expires time.Duration) (string, error) {
+	return s.presign("GET", o, "", s.now().Add(expires))
+}
+
+// a url anyone can GET o from until t, via v2 query-string signing
+func PresignGetUntil(auth aws.Auth, o Object, t time.Time) (string, error) {
+	return NewClient(auth).PresignGetUntil(o, t)
+}
+
+func (s SmartS3) PresignGetUntil(o Object, t time.Time) (string, error) {
+	return s.presign("GET", o, "", t)
+}
+
+// a url anyone can PUT o to until expires has elapsed; the uploader must send
+// exactly contentType as its Content-Type, which may be empty
+func (s SmartS3) PresignPut(o Object, contentType string, expires time.Duration) (string, error) {
+	return s.presign("PUT", o, contentType, s.now().Add(expires))
+}
+
+func (s SmartS3) presign(method string, o Object, ct string, expires time.Time) (string, error) {
+	if err := checkObject(o); err != nil {
+		return "", err
+	}
+	u, resource, err := s.createURL(o)
+	if err != nil {
+		return "", err
+	}
+	auth, err := s.credentials()
+	if err != nil {
+		return "", err
+	}
+	e := fmt.Sprint(expires.Unix())
+	var amz string
+	if auth.SessionToken != "" {
+		amz = "x-amz-security-token:" + auth.SessionToken + N
+	}
+	sig, err := sign(auth, method+N+N+ct+N+e+N+amz+resource)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{"AWSAccessKeyId": {auth.AccessKey}, "Expires": {e}, "Signature": {sig}}
+	if auth.SessionToken != "" {
+		q.Set("x-amz-security-token", auth.SessionToken)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// checks that rawurl is a GET url, as made by PresignGet, signed with these
+// credentials for an object of theirs, and not yet expired. the error wraps
+// ErrPresignInvalid or ErrPresignExpired.
+func (s SmartS3) ValidatePresigned(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPresignInvalid, err)
+	}
+	q := u.Query()
+	for _, k := range []string{"AWSAccessKeyId", "Expires", "Signature"} {
+		if q.Get(k) == "" {
+			return fmt.Errorf("%w: no %s", ErrPresignInvalid, k)
+		}
+	}
+	auth, err := s.credentials()
+	if err != nil {
+		return err
+	}
+	if q.Get("AWSAccessKeyId") != auth.AccessKey {
+		return fmt.Errorf("%w: signed by %s", ErrPresignInvalid, q.Get("AWSAccessKeyId"))
+	}
+	if q.Get("x-amz-security-token") != auth.SessionToken {
+		return fmt.Errorf("%w: wrong session token", ErrPresignInvalid)
+	}
+	e, err := strconv.ParseInt(q.Get("Expires"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad Expires %q", ErrPresignInvalid, q.Get("Expires"))
+	}
+	o, ok := s.presignedObject(u)
+	if !ok {
+		return fmt.Errorf("%w: %s isn't an object of %s", ErrPresignInvalid, u.Host+u.EscapedPath(), s.endpoint())
+	}
+	want, err := s.presign("GET", o, "", time.Unix(e, 0))
+	if err != nil {
+		return err
+	}
+	w, _ := url.Parse(want)
+	if !hmac.Equal([]byte(q.Get("Signature")), []byte(w.Query().Get("Signature"))) {
+		return fmt.Errorf("%w: signature mismatch", ErrPresignInvalid)
+	}
+	if exp := time.Unix(e, 0); s.clock().After(exp.Add(s.PresignSkew)) {
+		return fmt.Errorf("%w at %s", ErrPresignExpired, exp.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// the object a presigned url is for, trying it as path style, then as virtual
+// host style, and accepting whichever this client would have made it as
+func (s SmartS3) presignedObject(u *url.URL) (Object, bool) {
+	var candidates []Object
+	if p := strings.TrimPrefix(u.Path, "/"); strings.Contains(p, "/") {
+		i := strings.Index(p, "/")
+		candidates = append(candidates, Object{p[:i], p[i+1:]})
+	}
+	if i := strings.Index(u.Host, "."); i > 0 {
+		candidates = append(candidates, Object{u.Host[:i], strings.TrimPrefix(u.Path, "/")})
+	}
+	for _, o := range candidates {
+		if checkObject(o) != nil {
+			continue
+		}
+		c, _, err := s.createURL(o)
+		if err == nil && c.Scheme == u.Scheme && c.Host == u.Host && c.EscapedPath() == u.EscapedPath() {
+			return o, true
+		}
+	}
+	return Object{}, false
+}
@@ -0,0 +1,20 @@
+package s3
+
+import "io"
+
+// reports each read through to f
+type progressReader struct {
+	r     io.Reader
+	n     int64
+	total int64
+	f     ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.f(p.n, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package s3
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// the calls a ProgressFunc got
+type progressCalls [][2]int64
+
+func (p *progressCalls) f(n, total int64) {
+	*p = append(*p, [2]int64{n, total})
+}
+
+// whether the calls climbed steadily to a final one of total bytes
+func (p progressCalls) complete(total int64) bool {
+	for i := 1; i < len(p); i++ {
+		if p[i][0] < p[i-1][0] {
+			return false
+		}
+	}
+	return len(p) > 0 && p[len(p)-1] == [2]int64{total, total}
+}
+
+func TestProgress(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	data := make([]byte, 100<<10)
+
+	var up progressCalls
+	req := PutObjectRequest{Object: o, Data: data}
+	req.Progress = up.f
+	if err := s.PutObjectContext(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if !up.complete(int64(len(data))) {
+		t.Errorf("upload: got %v", up)
+	}
+
+	var down progressCalls
+	if err := s.GetToFile(ctx, o, filepath.Join(t.TempDir(), "k"), DownloadOptions{Progress: down.f}); err != nil {
+		t.Fatal(err)
+	}
+	if !down.complete(int64(len(data))) {
+		t.Errorf("download: got %v", down)
+	}
+}
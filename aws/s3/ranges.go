@@ -0,0 +1,209 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// the bytes of o from start through end inclusive, or when start is negative,
+// its last -start bytes, e.g. -22 for a zip's end of central directory. a
+// range running past the end of the object is cut short there.
+func GetRange(auth aws.Auth, o Object, start, end int64) ([]byte, error) {
+	return NewClient(auth).GetRange(context.Background(), o, start, end)
+}
+
+func (s SmartS3) GetRange(ctx context.Context, o Object, start, end int64) ([]byte, error) {
+	if err := checkObject(o); err != nil {
+		return nil, err
+	}
+	var header string
+	var n int64
+	switch {
+	case start < 0:
+		header, n = fmt.Sprintf("bytes=%d", start), -start
+	case end < start:
+		return nil, fmt.Errorf("bad range %d-%d", start, end)
+	default:
+		header, n = ByteRange{start, end}.header(), end-start+1
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		return s.getRange(ctx, GetRequest{Object: o}, header, n)
+	}
+	v, err := s.retry(ctx, o.Bucket, fmt.Sprintf("get %s of %s", header, print(o)), f)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// the body of a 206 for the range in header, which is at most n bytes
+func (s SmartS3) getRange(ctx context.Context, req GetRequest, header string, n int64) (out []byte, err error) {
+	hreq, err := s.rangesRequest(ctx, req, header)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("get", start, hreq, resp, err) }()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 206:
+	case 200:
+		return nil, fmt.Errorf("%w: %s answered %s with all %d bytes", ErrRangeIgnored, print(req.Object), header, resp.ContentLength)
+	default:
+		return nil, responseError(resp)
+	}
+	out, err = io.ReadAll(io.LimitReader(resp.Body, n+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > n {
+		return nil, fmt.Errorf("more than the %d bytes asked for in %s of %s", n, header, print(req.Object))
+	}
+	return out, nil
+}
+
+// fetches several ranges of o in one request, returning their bytes in the
+// order asked for. a store that answers with the whole object, or with the
+// ranges merged into one, is handled too. all of it is read into memory.
+func (s SmartS3) GetRanges(ctx context.Context, o Object, ranges []ByteRange) ([][]byte, error) {
+	if err := checkObject(o); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no ranges")
+	}
+	specs := make([]string, len(ranges))
+	for i, r := range ranges {
+		if r.Start < 0 || r.End < r.Start {
+			return nil, fmt.Errorf("bad range %d-%d", r.Start, r.End)
+		}
+		specs[i] = fmt.Sprintf("%d-%d", r.Start, r.End)
+	}
+	header := "bytes=" + strings.Join(specs, ",")
+	f := func(s SmartS3) (interface{}, error) {
+		return s.getRanges(ctx, GetRequest{Object: o}, header, ranges)
+	}
+	v, err := s.retry(ctx, o.Bucket, fmt.Sprintf("get ranges %s of %v", header, o), f)
+	if err != nil {
+		return nil, err
+	}
+	return v.([][]byte), nil
+}
+
+// bytes of an object starting at offset start, and the object's size, -1 if unknown
+type segment struct {
+	start int64
+	data  []byte
+	total int64
+}
+
+func (s SmartS3) getRanges(ctx context.Context, req GetRequest, header string, ranges []ByteRange) (out [][]byte, err error) {
+	hreq, err := s.rangesRequest(ctx, req, header)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := s.send(hreq)
+	defer func() { s.record("get", start, hreq, resp, err) }()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var segments []segment
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case resp.StatusCode == 200:
+		// the whole object
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment{0, data, int64(len(data))})
+	case resp.StatusCode == 206 && mediaType == "multipart/byteranges":
+		mr := multipart.NewReader(resp.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			seg, err := readSegment(part.Header.Get("Content-Range"), part)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, seg)
+		}
+	case resp.StatusCode == 206:
+		// a single range covering everything asked for
+		seg, err := readSegment(resp.Header.Get("Content-Range"), resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	default:
+		return nil, responseError(resp)
+	}
+	for _, r := range ranges {
+		b, ok := cut(segments, r)
+		if !ok {
+			return nil, fmt.Errorf("response for %s lacks bytes %d-%d", print(req.Object), r.Start, r.End)
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// reads a part whose Content-Range is cr, e.g. "bytes 100-199/1000"
+func readSegment(cr string, r io.Reader) (segment, error) {
+	var start, end int64
+	spec, total, ok := strings.Cut(strings.TrimPrefix(cr, "bytes "), "/")
+	if _, err := fmt.Sscanf(spec, "%d-%d", &start, &end); err != nil || !ok || end < start {
+		return segment{}, fmt.Errorf("bad content range %q", cr)
+	}
+	seg := segment{start: start, total: -1}
+	if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+		seg.total = n
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return segment{}, err
+	}
+	if int64(len(data)) != end-start+1 {
+		return segment{}, fmt.Errorf("content range %q but %d bytes", cr, len(data))
+	}
+	seg.data = data
+	return seg, nil
+}
+
+// the bytes of r from whichever segment holds all of it, or all of it that
+// the object does, since s3 clips a range running past the end
+func cut(segments []segment, r ByteRange) ([]byte, bool) {
+	for _, seg := range segments {
+		end := seg.start + int64(len(seg.data))
+		if r.Start < seg.start || r.Start >= end {
+			continue
+		}
+		if r.End < end {
+			return seg.data[r.Start-seg.start : r.End+1-seg.start], true
+		}
+		if end == seg.total {
+			return seg.data[r.Start-seg.start:], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,104 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestGetWithRange(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: []byte("0123456789")}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.GetWithResult(ctx, GetRequest{Object: o, Range: &ByteRange{2, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var b bytes.Buffer
+	b.ReadFrom(res.Body)
+	if b.String() != "234" || res.ContentRange != "bytes 2-4/10" || res.TotalSize != 10 || res.ContentLength != 3 {
+		t.Errorf("got %q, %+v", b.String(), res)
+	}
+}
+
+// a client of a server that answers every get with content via
+// http.ServeContent, multipart/byteranges and all
+func newContentClient(t *testing.T, content string) SmartS3 {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+}
+
+func TestGetRanges(t *testing.T) {
+	o := Object{"bkt", "k"}
+	ranges := []ByteRange{{8, 9}, {0, 1}, {4, 5}}
+	for name, s := range map[string]SmartS3{
+		"multipart":    newContentClient(t, "0123456789"),
+		"whole object": newMockClient(t),
+	} {
+		if name == "whole object" {
+			if err := s.PutObjectContext(context.Background(), PutObjectRequest{Object: o, Data: []byte("0123456789")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := s.GetRanges(context.Background(), o, ranges)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fmt.Sprintf("%s", got) != "[89 01 45]" {
+			t.Errorf("%s: got %s", name, got)
+		}
+	}
+	s := newMockClient(t)
+	for _, bad := range [][]ByteRange{nil, {{5, 4}}, {{-1, 2}}} {
+		if _, err := s.GetRanges(context.Background(), o, bad); err == nil {
+			t.Errorf("no error for ranges %v", bad)
+		}
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	s := newContentClient(t, "0123456789")
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	for _, c := range []struct {
+		start, end int64
+		want       string
+	}{
+		{2, 4, "234"},
+		{-3, 0, "789"},
+		{8, 20, "89"},
+	} {
+		got, err := s.GetRange(ctx, o, c.start, c.end)
+		if err != nil || string(got) != c.want {
+			t.Errorf("%d-%d: got %q, %v", c.start, c.end, got, err)
+		}
+	}
+	if _, err := s.GetRange(ctx, o, 4, 2); err == nil {
+		t.Error("no error for an end before the start")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "0123456789")
+	}))
+	defer srv.Close()
+	s.Endpoint = srv.URL
+	if _, err := s.GetRange(ctx, o, 2, 4); !errors.Is(err, ErrRangeIgnored) {
+		t.Errorf("range ignored: got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package s3
+
+import (
+	"errors"
+	"strings"
+)
+
+// where a bucket turned out to live, learned from a redirect
+type bucketLocation struct {
+	endpoint, region string
+}
+
+type locationKey struct {
+	endpoint, bucket string
+}
+
+func (s SmartS3) location(bucket string) bucketLocation {
+	if s.detour != nil && s.detour.bucket == bucket {
+		return s.detour.bucketLocation
+	}
+	if s.state != nil {
+		if v, ok := s.state.locations.Load(locationKey{s.endpoint(), bucket}); ok {
+			return v.(bucketLocation)
+		}
+	}
+	return bucketLocation{endpoint: s.endpoint(), region: s.Auth.GetRegion()}
+}
+
+// the base url for requests to bucket
+func (s SmartS3) endpointFor(bucket string) string {
+	return s.location(bucket).endpoint
+}
+
+// the region to sign requests to bucket for
+func (s SmartS3) regionFor(bucket string) string {
+	return s.location(bucket).region
+}
+
+// a location one copy of a client uses for a bucket, having followed a
+// redirect that isn't to be remembered
+type detour struct {
+	bucket string
+	bucketLocation
+}
+
+// works out where a redirect error says bucket lives, returning a client that
+// sends there and whether that's somewhere new, and so worth trying the
+// request again. what's lasting, a 301 or a 400 naming the bucket's region,
+// is remembered by a client made by NewClient; a 307 is only followed.
+func (s SmartS3) followRedirect(bucket string, err error) (SmartS3, bool) {
+	var e *S3Error
+	if bucket == "" || !errors.As(err, &e) {
+		return s, false
+	}
+	switch e.HTTPStatusCode {
+	case 301, 307:
+	case 400:
+		// v4 signed for the wrong region
+		if e.Region == "" {
+			return s, false
+		}
+	default:
+		return s, false
+	}
+	old := s.location(bucket)
+	loc := old
+	if e.Endpoint != "" {
+		loc.endpoint = strings.TrimSuffix(e.Endpoint, "/")
+		if !strings.Contains(loc.endpoint, "://") {
+			loc.endpoint = "https://" + loc.endpoint
+		}
+		// s3 names the virtual host, so take the bucket back out
+		loc.endpoint = strings.Replace(loc.endpoint, "://"+bucket+".", "://", 1)
+	} else if e.Region != "" && s.Endpoint == "" {
+		loc.endpoint = s.regionalEndpoint(e.Region)
+	}
+	if e.Region != "" {
+		loc.region = e.Region
+	}
+	if loc == old {
+		return s, false
+	}
+	if e.HTTPStatusCode != 307 && s.state != nil {
+		s.state.locations.Store(locationKey{s.endpoint(), bucket}, loc)
+		return s, true
+	}
+	s.detour = &detour{bucket, loc}
+	return s, true
+}
+
+// the bucket named by a path-style resource such as "/bucket/key?acl"
+func bucketOf(resource string) string {
+	b := strings.TrimPrefix(resource, "/")
+	if i := strings.IndexAny(b, "/?"); i >= 0 {
+		b = b[:i]
+	}
+	return b
+}
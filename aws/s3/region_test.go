@@ -0,0 +1,116 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil"
+	"github.com/xoba/goutil/aws"
+)
+
+// an endpoint that redirects every request to another, with the given status
+// and, when not empty, x-amz-bucket-region
+func redirector(status int, to, region string, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		if region != "" {
+			w.Header().Set("X-Amz-Bucket-Region", region)
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<Error><Code>Redirect</Code><Endpoint>%s</Endpoint></Error>", to)
+	}))
+}
+
+func redirectClient(endpoint string) SmartS3 {
+	s := NewClient(aws.Auth{AccessKey: "a", SecretKey: "b"})
+	s.Endpoint = endpoint
+	s.Strat = goutil.RetryBackoffStrat{Delay: time.Millisecond}
+	return s
+}
+
+func TestPermanentRedirect(t *testing.T) {
+	var realHits, oldHits int32
+	real := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&realHits, 1)
+		fmt.Fprint(w, "data")
+	}))
+	defer real.Close()
+	old := redirector(http.StatusMovedPermanently, real.URL, "", &oldHits)
+	defer old.Close()
+
+	s := redirectClient(old.URL)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		b, err := s.GetObjectContext(ctx, GetRequest{Object: Object{"bkt", "k"}})
+		if err != nil || string(b) != "data" {
+			t.Fatal(err, b)
+		}
+	}
+	if oldHits != 1 || realHits != 2 {
+		t.Errorf("old endpoint hit %d times, new %d; want 1 and 2", oldHits, realHits)
+	}
+
+	// another client hasn't learned it
+	if _, err := redirectClient(old.URL).GetObjectContext(ctx, GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	if oldHits != 2 {
+		t.Errorf("a new client found the bucket without asking the old endpoint")
+	}
+}
+
+func TestTemporaryRedirect(t *testing.T) {
+	var realHits, oldHits int32
+	real := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&realHits, 1)
+	}))
+	defer real.Close()
+	for _, region := range []string{"", "eu-west-1"} {
+		realHits, oldHits = 0, 0
+		old := redirector(http.StatusTemporaryRedirect, real.URL, region, &oldHits)
+		defer old.Close()
+		s := redirectClient(old.URL)
+		for i := 0; i < 2; i++ {
+			if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if oldHits != 2 || realHits != 2 {
+			t.Errorf("region %q: old endpoint hit %d times, new %d; want each request redirected", region, oldHits, realHits)
+		}
+		if s.endpointFor("bkt") != old.URL {
+			t.Errorf("region %q: a 307 was remembered", region)
+		}
+	}
+}
+
+func TestRedirectUnremembered(t *testing.T) {
+	var realHits, oldHits int32
+	var tagged string
+	real := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&realHits, 1)
+		tagged = r.URL.RawQuery
+	}))
+	defer real.Close()
+	old := redirector(http.StatusMovedPermanently, real.URL, "", &oldHits)
+	defer old.Close()
+
+	// a client made without NewClient still follows, and signs for, the redirect
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: old.URL}
+	ctx := context.Background()
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	// as do requests built before it's followed
+	if err := s.PutObjectTagging(ctx, Object{"bkt", "k"}, map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if oldHits != 2 || realHits != 2 || tagged != "tagging" {
+		t.Errorf("old endpoint hit %d times, new %d with query %q", oldHits, realHits, tagged)
+	}
+}
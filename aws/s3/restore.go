@@ -0,0 +1,93 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// retrieval tiers for RestoreObject, fastest and dearest last
+const (
+	TierBulk      = "Bulk"
+	TierStandard  = "Standard"
+	TierExpedited = "Expedited"
+)
+
+// RestoreObject found a restore of the object already under way
+var ErrRestoreInProgress = errors.New("restore already in progress")
+
+type restoreRequest struct {
+	XMLName              xml.Name `xml:"RestoreRequest"`
+	Days                 int
+	GlacierJobParameters *glacierJobParameters `xml:",omitempty"`
+}
+
+type glacierJobParameters struct {
+	Tier string
+}
+
+// asks for a temporary copy of an archived object, one in GLACIER or
+// DEEP_ARCHIVE, to be made readable for days. see SmartS3.RestoreObject.
+func RestoreObject(auth aws.Auth, o Object, days int, tier string) (bool, error) {
+	return NewClient(auth).RestoreObject(context.Background(), o, days, tier)
+}
+
+// asks for a temporary copy of an archived object to be made readable for
+// days, fetched at tier, or s3's default (TierStandard) when empty. it reports
+// true when s3 accepted the restore, which then takes minutes to hours
+// depending on the tier, and false when o was already restored, which just
+// moves its expiry to days from now. a restore already under way is
+// ErrRestoreInProgress.
+func (s SmartS3) RestoreObject(ctx context.Context, o Object, days int, tier string) (bool, error) {
+	if err := checkObject(o); err != nil {
+		return false, err
+	}
+	if days < 1 {
+		return false, fmt.Errorf("can't restore %s for %d days", print(o), days)
+	}
+	switch tier {
+	case "", TierBulk, TierStandard, TierExpedited:
+	default:
+		return false, fmt.Errorf("unknown retrieval tier %q", tier)
+	}
+	doc := restoreRequest{Days: days}
+	if tier != "" {
+		doc.GlacierJobParameters = &glacierJobParameters{Tier: tier}
+	}
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	sum, err := contentMD5(bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	u, resource, err := s.subresourceURL(o, url.Values{"restore": {""}})
+	if err != nil {
+		return false, err
+	}
+	h := http.Header{"Content-Type": {"application/xml"}, "Content-Md5": {sum}}
+	f := func(s SmartS3) (interface{}, error) {
+		resp, err := s.do(ctx, "POST", u, resource, h, body)
+		var e *S3Error
+		if errors.As(err, &e) && e.Code == "RestoreAlreadyInProgress" {
+			return nil, fmt.Errorf("%w: %w", ErrRestoreInProgress, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusAccepted, nil
+	}
+	v, err := s.retry(ctx, o.Bucket, fmt.Sprintf("restore %s", print(o)), f)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
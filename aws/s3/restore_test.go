@@ -0,0 +1,63 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestRestoreObject(t *testing.T) {
+	var (
+		body   string
+		status int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.RawQuery != "restore" {
+			t.Errorf("got %s %s", r.Method, r.URL)
+		}
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(status)
+		if status == http.StatusConflict {
+			io.WriteString(w, "<Error><Code>RestoreAlreadyInProgress</Code></Error>")
+		}
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+
+	status = http.StatusAccepted
+	ok, err := s.RestoreObject(ctx, o, 3, TierBulk)
+	if err != nil || !ok {
+		t.Errorf("got %v, %v", ok, err)
+	}
+	if want := "<RestoreRequest><Days>3</Days><GlacierJobParameters><Tier>Bulk</Tier></GlacierJobParameters></RestoreRequest>"; body != want {
+		t.Errorf("sent %s\nwant %s", body, want)
+	}
+
+	status = http.StatusOK
+	ok, err = s.RestoreObject(ctx, o, 3, "")
+	if err != nil || ok {
+		t.Errorf("already restored: got %v, %v", ok, err)
+	}
+	if body != "<RestoreRequest><Days>3</Days></RestoreRequest>" {
+		t.Errorf("sent %s without a tier", body)
+	}
+
+	status = http.StatusConflict
+	if _, err := s.RestoreObject(ctx, o, 3, ""); !errors.Is(err, ErrRestoreInProgress) {
+		t.Errorf("in progress: got %v", err)
+	}
+	if _, err := s.RestoreObject(ctx, o, 0, ""); err == nil {
+		t.Error("restored for 0 days")
+	}
+	if _, err := s.RestoreObject(ctx, o, 1, "Glacial"); err == nil {
+		t.Error("restored at an unknown tier")
+	}
+}
@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultRetryDelay    = 100 * time.Millisecond
+	DefaultMaxRetryDelay = 20 * time.Second
+)
+
+// how transient failures are retried at the transport level. the zero value makes a single attempt.
+type RetryPolicy struct {
+	// attempts in all, including the first
+	MaxAttempts int
+	// wait before the first retry, doubling each time after, plus jitter; DefaultRetryDelay when zero
+	Delay time.Duration
+	// the most Delay doubles up to, before jitter; DefaultMaxRetryDelay when zero
+	MaxDelay time.Duration
+	// also retry PUT and POST, which are otherwise only attempted once
+	RetryWrites bool
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p RetryPolicy) retryable(hreq *http.Request, resp *http.Response, err error) bool {
+	switch hreq.Method {
+	case "GET", "HEAD", "DELETE":
+	default:
+		if !p.RetryWrites {
+			return false
+		}
+	}
+	if hreq.Body != nil && hreq.Body != http.NoBody && hreq.GetBody == nil {
+		return false
+	}
+	if hreq.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return transient(err)
+	}
+	switch resp.StatusCode {
+	case 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// whether a failure to get any response might pass: a timeout, a reset or
+// refused connection, or one dropped mid-response. tls, certificate and dns
+// failures won't fix themselves.
+func transient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// how long to wait before the retry following the given attempt, honoring any Retry-After
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return d
+		}
+	}
+	d := p.Delay
+	if d <= 0 {
+		d = DefaultRetryDelay
+	}
+	max := p.MaxDelay
+	if max <= 0 {
+		max = DefaultMaxRetryDelay
+	}
+	// doubled step by step, since shifting by the attempt count can overflow
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration(rand.Int63n(int64(d)))
+}
+
+// parses a Retry-After of either delay-seconds or an http date
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// a copy of hreq with a fresh body, ready to be sent again
+func rewind(hreq *http.Request) (*http.Request, error) {
+	next := hreq.Clone(hreq.Context())
+	if hreq.GetBody != nil {
+		body, err := hreq.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
@@ -0,0 +1,139 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil"
+	"github.com/xoba/goutil/aws"
+)
+
+// answers the first fails requests with 503, after reading part of any body,
+// and the rest with 200, recording each body it read
+type flakyServer struct {
+	mu     sync.Mutex
+	fails  int
+	bodies [][]byte
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.bodies) < f.fails {
+		b := make([]byte, 100)
+		n, _ := io.ReadFull(r.Body, b)
+		f.bodies = append(f.bodies, b[:n])
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	b, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, b)
+	w.Header().Set("ETag", `"x"`)
+}
+
+func flakyClient(url string) SmartS3 {
+	return SmartS3{
+		Auth:     aws.Auth{AccessKey: "a", SecretKey: "b"},
+		Endpoint: url,
+		Strat:    goutil.RetryBackoffStrat{Delay: time.Millisecond},
+		Retry:    RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond, RetryWrites: true},
+	}
+}
+
+func TestRetryThenSucceed(t *testing.T) {
+	f := &flakyServer{fails: 2}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	s := flakyClient(srv.URL)
+	s.Retry.RetryWrites = false
+	if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.bodies) != 3 {
+		t.Errorf("%d attempts, want 3", len(f.bodies))
+	}
+}
+
+func TestRetryRereadsBody(t *testing.T) {
+	f := &flakyServer{fails: 1}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	err := flakyClient(srv.URL).Put(PutRequest{Object: Object{"bkt", "k"}, ReaderFact: goutil.BufferReaderFact{Buffer: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.bodies) != 2 || !bytes.Equal(f.bodies[1], data) {
+		t.Fatalf("%d attempts; the retry sent %d bytes of %d", len(f.bodies), len(f.bodies[len(f.bodies)-1]), len(data))
+	}
+}
+
+func TestPutReaderNotRetried(t *testing.T) {
+	f := &flakyServer{fails: 1}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	s := flakyClient(srv.URL)
+	data := bytes.Repeat([]byte("x"), 1000)
+	if _, err := s.PutReader(context.Background(), Object{"bkt", "k"}, bytes.NewReader(data), int64(len(data)), ""); err == nil {
+		t.Fatal("want the 503")
+	}
+	if len(f.bodies) != 1 {
+		t.Errorf("%d attempts, want 1", len(f.bodies))
+	}
+}
+
+// the number of attempts at a get from url, as seen by Trace
+func attempts(t *testing.T, s SmartS3) int {
+	n := 0
+	s.Trace = func(e TraceEvent) {
+		if e.StringToSign == "" {
+			n++
+		}
+	}
+	s.Timeout = 2 * time.Second
+	if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err == nil {
+		t.Fatal("get succeeded")
+	}
+	return n
+}
+
+func TestRetryNetworkErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	refused := srv.URL
+	srv.Close()
+	s := flakyClient(refused)
+	if n := attempts(t, s); n != 3 {
+		t.Errorf("refused connection: %d attempts, want 3", n)
+	}
+
+	// an untrusted certificate
+	tls := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tls.Close()
+	s = flakyClient(tls.URL)
+	if n := attempts(t, s); n != 1 {
+		t.Errorf("bad certificate: %d attempts, want 1", n)
+	}
+}
+
+func TestBackoffCapped(t *testing.T) {
+	for _, p := range []RetryPolicy{{}, {Delay: time.Second, MaxDelay: time.Minute}} {
+		max := p.MaxDelay
+		if max == 0 {
+			max = DefaultMaxRetryDelay
+		}
+		for _, attempt := range []int{1, 2, 30, 70} {
+			if d := p.backoff(attempt, nil); d <= 0 || d > 2*max {
+				t.Errorf("%+v: attempt %d waits %v", p, attempt, d)
+			}
+		}
+		if d := p.backoff(70, nil); d < max {
+			t.Errorf("%+v: attempt 70 waits only %v", p, d)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package s3
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/xoba/goutil"
 	"github.com/xoba/goutil/aws"
 	"io"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -18,23 +22,183 @@ type Interface interface {
 }
 
 func GetDefault(a aws.Auth) Interface {
-	return SmartS3{Auth: a, Strat: &goutil.RetryBackoffStrat{BackoffFactor: 1.5, Delay: time.Second, Retries: 5}}
+	return NewClient(a)
+}
+
+// a client for a with the default retry strategy; set its other fields to
+// change the endpoint, transport, timeouts and so on
+func NewClient(a aws.Auth) SmartS3 {
+	return SmartS3{Auth: a, Strat: &goutil.RetryBackoffStrat{BackoffFactor: 1.5, Delay: time.Second, Retries: 5}, state: new(clientState)}
+}
+
+// what a client made by NewClient learns as it goes, shared by its copies
+type clientState struct {
+	// bucketLocation by configured endpoint and bucket
+	locations sync.Map
+	// how far s3's clock is ahead of ours, by endpoint, as learned from
+	// RequestTimeTooSkewed errors
+	skews sync.Map
+	// the credentials last retrieved, and from which provider
+	mu       sync.Mutex
+	provider aws.CredentialProvider
+	auth     aws.Auth
 }
 
 type GetRequest struct {
 	Object Object
+	// in a versioned bucket, fetch this version rather than the latest
+	VersionID string
+	// fetch only these bytes of the object
+	Range *ByteRange
+	// conditional get: when the object is unchanged the result is ErrNotModified
+	IfModifiedSince time.Time
+	IfNoneMatch     string
+	// for GetObject, fail with ErrTooLarge rather than read more than this into
+	// memory; no limit when zero, which trusts whatever the bucket holds
+	MaxBytes int64
+	// gunzip the body when the object was stored with Content-Encoding: gzip;
+	// otherwise it comes back exactly as stored
+	Decompress bool
+}
+
+// inclusive byte offsets, as in the http Range header
+type ByteRange struct {
+	Start, End int64
+}
+
+// the body of a fetched object, along with what the response said about it
+type GetResult struct {
+	Body io.ReadCloser
+	// length of the body as sent, so of the compressed bytes when Decompress applies; -1 if unknown
+	ContentLength int64
+	ContentType   string
+	ETag          string
+	LastModified  time.Time
+	// user metadata, keyed by lowercased name without the x-amz-meta- prefix
+	Metadata map[string]string
+	// as sent by s3 for a ranged request, e.g. "bytes 100-199/1000"
+	ContentRange string
+	// size of the whole object, which is more than the body for a ranged request; -1 if unknown
+	TotalSize int64
+	// caching and download headers given when the object was put
+	CacheControl       string
+	ContentDisposition string
+	Expires            time.Time
+}
+
+type HeadRequest struct {
+	Object Object
+	// also ask for the checksums stored with the object, which for a kms
+	// encrypted one needs permission to decrypt
+	Checksums bool
+}
+
+// attributes of an object, as returned by a HEAD request
+type ObjectInfo struct {
+	ContentLength int64
+	ETag          string
+	ContentType   string
+	// e.g. "gzip", as given when the object was put
+	ContentEncoding string
+	// caching and download headers, as given when the object was put
+	CacheControl       string
+	ContentDisposition string
+	Expires            time.Time
+	LastModified       time.Time
+	// user metadata from the x-amz-meta-* headers, keyed by lowercased name without the prefix
+	Metadata map[string]string
+	// how s3 encrypted the object at rest, if it did
+	ServerSideEncryption string
+	KMSKeyID             string
+	// empty for STANDARD, which s3 doesn't report
+	StorageClass string
+	// as given when the object was put
+	WebsiteRedirectLocation string
+	// the object's lock, if any
+	ObjectLockMode        string
+	ObjectLockRetainUntil time.Time
+	ObjectLockLegalHold   bool
+	// base64 checksums given at upload, when HeadRequest.Checksums asked for them
+	ChecksumCRC32, ChecksumCRC32C, ChecksumSHA1, ChecksumSHA256 string
+	// of the response this came from; those of others are on SmartS3.Trace's events
+	IDs RequestIDs
 }
 
 type PutRequest struct {
 	Object      Object
 	ContentType string
 	ReaderFact  goutil.ReaderFactory
+	// send a Content-MD5 so s3 rejects a body corrupted in transit; costs an extra pass over the reader
+	ContentMD5 bool
+	PutOptions
+}
+
+// settings shared by every kind of upload
+type PutOptions struct {
+	// user metadata, sent as x-amz-meta-* headers; names are lowercased
+	Metadata map[string]string
+	// a canned acl such as "public-read", sent as x-amz-acl
+	ACL string
+	// when there's no content type and the key's extension doesn't imply one,
+	// detect it from the start of the body rather than using application/octet-stream
+	SniffContentType bool
+	// encryption at rest: SSES3 or SSEKMS, the latter optionally with a key other than the account default
+	ServerSideEncryption string
+	KMSKeyID             string
+	// e.g. "STANDARD_IA" or "GLACIER"; s3 uses STANDARD when empty
+	StorageClass string
+	// e.g. "gzip" for a body that was compressed before upload, so that
+	// browsers and Decompress know to undo it
+	ContentEncoding string
+	// served back as the standard headers, e.g. "max-age=3600" and
+	// `attachment; filename="report.pdf"`; s3 stores them with the object
+	CacheControl       string
+	ContentDisposition string
+	Expires            time.Time
+	// where a website-enabled bucket redirects requests for the object, either
+	// another key such as "/new/page.html" or a full url
+	WebsiteRedirectLocation string
+	// object lock, for buckets that have it enabled: a mode, LockGovernance
+	// or LockCompliance, with the time until which it applies, and/or a legal
+	// hold. s3 wants a ContentMD5 or checksum on such puts.
+	ObjectLockMode        string
+	ObjectLockRetainUntil time.Time
+	ObjectLockLegalHold   bool
+	// one of the Checksum* algorithms, to have s3 verify the body against a
+	// checksum computed here and store it with the object. when Checksum is
+	// also given, the upload fails before anything is sent unless the two agree.
+	ChecksumAlgorithm string
+	Checksum          string
+	// called as the body is sent, with the bytes so far and the total
+	Progress ProgressFunc
+}
+
+// reports a transfer's progress; total is -1 when unknown
+type ProgressFunc func(bytesSoFar, total int64)
+
+// values for PutOptions.ServerSideEncryption
+const (
+	SSES3  = "AES256"
+	SSEKMS = "aws:kms"
+)
+
+// what s3 reports about a completed upload
+type PutResult struct {
+	ETag string
+	// empty unless the bucket has versioning enabled
+	VersionID string
+	// how s3 encrypted the object at rest, if it did
+	ServerSideEncryption string
+	KMSKeyID             string
 }
 
 type PutObjectRequest struct {
 	Object      Object
 	ContentType string
 	Data        []byte
+	// send a Content-MD5 so s3 rejects a body corrupted in transit
+	ContentMD5 bool
+	PutOptions
 }
 
 type ListRequest struct {
@@ -42,10 +206,21 @@ type ListRequest struct {
 	MaxKeys int64
 	Marker  string
 	Prefix  string
+	// e.g. "/" to roll keys up into CommonPrefixes, folder-style
+	Delimiter string
+	// "url" has s3 percent-encode the keys in its response, for keys with
+	// characters xml can't carry; they're decoded again before being returned
+	EncodingType string
 }
 
 type DeleteRequest struct {
 	Object Object
+	// delete only if the object's etag matches, or doesn't; otherwise the
+	// error wraps ErrPreconditionFailed
+	IfMatch, IfNoneMatch string
+	// in a versioned bucket, permanently remove this version rather than
+	// adding a delete marker
+	VersionID string
 }
 
 type Object struct {
@@ -53,38 +228,137 @@ type Object struct {
 	Key    string
 }
 
+// one key of a listing. the etag is quoted as s3 sends it; owner is only
+// reported when the bucket's settings allow.
 type ListBucketResultContents struct {
-	Key, ETag, StorageClass string
-	Size                    int
-	Owner                   ListBucketResultOwner
-	LastModified            time.Time
+	Key          string                `xml:"Key"`
+	ETag         string                `xml:"ETag"`
+	StorageClass string                `xml:"StorageClass"`
+	Size         int                   `xml:"Size"`
+	Owner        ListBucketResultOwner `xml:"Owner"`
+	LastModified time.Time             `xml:"LastModified"`
 }
 
 type ListBucketResultOwner struct {
-	ID, DisplayName string
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
 }
 
+// one page of a listing. to page through it by hand, repeat the request with
+// Marker set to NextPageMarker() for as long as IsTruncated.
 type ListBucketResult struct {
-	Name, Prefix, Marker, Delimiter string
-	MaxKeys                         int64
-	IsTruncated                     bool
-	Contents                        []ListBucketResultContents
+	Name      string `xml:"Name"`
+	Prefix    string `xml:"Prefix"`
+	Marker    string `xml:"Marker"`
+	Delimiter string `xml:"Delimiter"`
+	// where the next page starts; s3 only sends it when a Delimiter was given
+	NextMarker string `xml:"NextMarker"`
+	// the most keys and common prefixes the page could have held
+	MaxKeys int64 `xml:"MaxKeys"`
+	// how many keys and common prefixes the page holds, when the store says
+	KeyCount int64 `xml:"KeyCount"`
+	// whether there are more pages after this one
+	IsTruncated    bool                       `xml:"IsTruncated"`
+	Contents       []ListBucketResultContents `xml:"Contents"`
+	CommonPrefixes []string                   `xml:"CommonPrefixes>Prefix"`
 }
 
+// the s3 client: credentials plus the settings shared by every request made
+// through it. methods have value receivers, so copy and adjust one freely.
 type SmartS3 struct {
 	Auth  aws.Auth
 	Strat goutil.RetryStrategy
+	// where requests get their credentials, in place of Auth, when set. a
+	// client made by NewClient keeps what it retrieves until IsExpired; any
+	// other asks it for every request, so it should cache them itself, as
+	// those of package aws do.
+	Credentials aws.CredentialProvider
+	// sign requests with signature version 4 (required in newer regions) rather than v2
+	SigV4 bool
+	// host or base url of the service, e.g. "s3.eu-west-1.amazonaws.com" or
+	// "http://localhost:9000" for s3-compatible stores; DefaultEndpoint when empty
+	Endpoint string
+	// with no Endpoint, use aws's fips-validated or ipv6-capable endpoints, or
+	// both, in Auth's region
+	UseFIPS, UseDualStack bool
+	// address buckets as endpoint/bucket/key rather than bucket.endpoint/key, as
+	// s3-compatible stores often need. buckets that can't be hostnames, and ip
+	// endpoints, always use path style.
+	PathStyle bool
+	// for timeouts, proxies, tls settings and connection reuse; http.DefaultClient when nil
+	HTTPClient *http.Client
+	// bounds each request from connecting through reading the body; DefaultTimeout
+	// when zero, none when negative. raise it for large objects on slow links.
+	Timeout time.Duration
+	// transport-level retries of 5xx responses and transient network errors, beneath those of Strat
+	Retry RetryPolicy
+	// the clock requests are dated and signed by; time.Now when nil
+	Now func() time.Time
+	// puts of at least this many bytes send Expect: 100-continue, so that s3
+	// can refuse one, say for a bad signature, before the body is sent; never
+	// when zero. this relies on the transport having an ExpectContinueTimeout,
+	// as http.DefaultTransport does.
+	ExpectContinue int64
+	// identifies the caller in s3's access logs, after DefaultUserAgent, e.g. "mytool/2.1"
+	UserAgent string
+	// agree to pay for requests to requester-pays buckets, which otherwise refuse them
+	RequesterPays bool
+	// caps the rate each request body is sent, and each object body read, at;
+	// unlimited when zero
+	BytesPerSecond int64
+	// when set, called as each request is signed and again as its response
+	// arrives, to help debug signatures
+	Trace func(TraceEvent)
+	// when set, called with each request after it's signed and before each
+	// attempt to send it, e.g. to add a gateway's trace or auth headers.
+	// changing anything that was signed, such as Date or an x-amz-* header,
+	// invalidates the signature.
+	RequestModifier func(*http.Request)
+	// when set, given the latency and size of each request
+	Metrics MetricsRecorder
+	// how long past its expiry ValidatePresigned still accepts a url, allowing
+	// for the clock of whoever signed it being behind ours
+	PresignSkew time.Duration
+
+	// where buckets live and the current credentials, when made by NewClient
+	state *clientState
+	// set on the copy that retries a request where a redirect said to go
+	detour *detour
 }
 
 func (s SmartS3) List(req ListRequest) (ListBucketResult, error) {
+	return s.ListContext(context.Background(), req)
+}
+
+func (s SmartS3) Get(req GetRequest) (io.ReadCloser, error) {
+	return s.GetContext(context.Background(), req)
+}
+
+func (s SmartS3) GetObject(req GetRequest) ([]byte, error) {
+	return s.GetObjectContext(context.Background(), req)
+}
+
+func (s SmartS3) Put(req PutRequest) error {
+	return s.PutContext(context.Background(), req)
+}
+
+func (s SmartS3) PutObject(req PutObjectRequest) error {
+	return s.PutObjectContext(context.Background(), req)
+}
+
+func (s SmartS3) Delete(req DeleteRequest) error {
+	return s.DeleteContext(context.Background(), req)
+}
+
+func (s SmartS3) ListContext(ctx context.Context, req ListRequest) (ListBucketResult, error) {
 	var out ListBucketResult
-	if req.Bucket == "" {
-		return out, errors.New("no bucket name")
+	if err := ValidateBucketName(req.Bucket); err != nil {
+		return out, err
 	}
-	f := func() (interface{}, error) {
-		return list(s.Auth, req)
+	f := func(s SmartS3) (interface{}, error) {
+		return s.list(ctx, req)
 	}
-	v, err := s.retry(print(req), f)
+	v, err := s.retry(ctx, req.Bucket, print(req), f)
 	if err != nil {
 		return out, err
 	} else {
@@ -92,30 +366,153 @@ func (s SmartS3) List(req ListRequest) (ListBucketResult, error) {
 	}
 }
 
-func (s SmartS3) Get(req GetRequest) (io.ReadCloser, error) {
-	err := checkObject(req.Object)
+// lists every key matching req, following truncated results page by page
+func (s SmartS3) ListAll(req ListRequest) (ListBucketResult, error) {
+	return s.ListAllContext(context.Background(), req)
+}
+
+// pages with the version 2 api, falling back to markers for stores that
+// answer it as if it were version 1
+func (s SmartS3) ListAllContext(ctx context.Context, req ListRequest) (ListBucketResult, error) {
+	v2 := ListV2Request{
+		Bucket:       req.Bucket,
+		Prefix:       req.Prefix,
+		Delimiter:    req.Delimiter,
+		MaxKeys:      req.MaxKeys,
+		StartAfter:   req.Marker,
+		EncodingType: req.EncodingType,
+	}
+	out := ListBucketResult{Marker: req.Marker}
+	for first := true; ; first = false {
+		page, err := s.ListV2(ctx, v2)
+		if err != nil {
+			return out, err
+		}
+		if first {
+			out.Name, out.Prefix, out.Delimiter, out.MaxKeys = page.Name, page.Prefix, page.Delimiter, page.MaxKeys
+		}
+		out.Contents = append(out.Contents, page.Contents...)
+		out.CommonPrefixes = append(out.CommonPrefixes, page.CommonPrefixes...)
+		switch {
+		case !page.IsTruncated:
+			out.KeyCount = int64(len(out.Contents) + len(out.CommonPrefixes))
+			return out, nil
+		case page.NextContinuationToken == "":
+			// no token, so the store took this for a version 1 listing
+			v1 := ListBucketResult{Contents: page.Contents, CommonPrefixes: page.CommonPrefixes, IsTruncated: true}
+			if err := advance(&req, v1); err != nil {
+				return out, err
+			}
+			rest, err := s.listAllV1(ctx, req)
+			out.Contents = append(out.Contents, rest.Contents...)
+			out.CommonPrefixes = append(out.CommonPrefixes, rest.CommonPrefixes...)
+			out.KeyCount = int64(len(out.Contents) + len(out.CommonPrefixes))
+			return out, err
+		case page.NextContinuationToken == v2.ContinuationToken:
+			return out, fmt.Errorf("truncated listing of %s made no progress past token %q", req.Bucket, v2.ContinuationToken)
+		}
+		v2.ContinuationToken = page.NextContinuationToken
+	}
+}
+
+func (s SmartS3) listAllV1(ctx context.Context, req ListRequest) (ListBucketResult, error) {
+	var out ListBucketResult
+	for first := true; ; first = false {
+		page, err := s.ListContext(ctx, req)
+		if err != nil {
+			return out, err
+		}
+		if first {
+			out = page
+		} else {
+			out.Contents = append(out.Contents, page.Contents...)
+			out.CommonPrefixes = append(out.CommonPrefixes, page.CommonPrefixes...)
+		}
+		if !page.IsTruncated {
+			out.IsTruncated = false
+			out.NextMarker = ""
+			out.KeyCount = int64(len(out.Contents) + len(out.CommonPrefixes))
+			return out, nil
+		}
+		if err := advance(&req, page); err != nil {
+			return out, err
+		}
+	}
+}
+
+// moves req's marker past a truncated page, failing if that wouldn't make progress
+func advance(req *ListRequest, page ListBucketResult) error {
+	next := page.NextPageMarker()
+	if next == "" || next == req.Marker {
+		return fmt.Errorf("truncated listing of %s made no progress past marker %q", req.Bucket, req.Marker)
+	}
+	req.Marker = next
+	return nil
+}
+
+// the marker for the page following r: NextMarker when s3 sends one, else the
+// greater of the last key and the last common prefix
+func (r ListBucketResult) NextPageMarker() (m string) {
+	if r.NextMarker != "" {
+		return r.NextMarker
+	}
+	if n := len(r.Contents); n > 0 {
+		m = r.Contents[n-1].Key
+	}
+	if n := len(r.CommonPrefixes); n > 0 && r.CommonPrefixes[n-1] > m {
+		m = r.CommonPrefixes[n-1]
+	}
+	return
+}
+
+func (s SmartS3) GetContext(ctx context.Context, req GetRequest) (io.ReadCloser, error) {
+	res, err := s.GetWithResult(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	f := func() (interface{}, error) {
-		return get(s.Auth, req)
+	return res.Body, nil
+}
+
+// signs and sends a get, returning the response as it came whatever its
+// status, for callers who need headers the package doesn't model. the caller
+// must close the body. there are no retries beyond those of s.Retry.
+func (s SmartS3) GetRaw(ctx context.Context, req GetRequest) (*http.Response, error) {
+	if err := checkObject(req.Object); err != nil {
+		return nil, err
 	}
-	v, err := s.retry(print(req), f)
+	hreq, err := s.getRequest(ctx, req)
 	if err != nil {
 		return nil, err
+	}
+	return s.send(hreq)
+}
+
+// like GetContext, but also returns what the response said about the object
+func (s SmartS3) GetWithResult(ctx context.Context, req GetRequest) (GetResult, error) {
+	err := checkObject(req.Object)
+	if err != nil {
+		return GetResult{}, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		return s.get(ctx, req)
+	}
+	v, err := s.retry(ctx, req.Object.Bucket, print(req), f)
+	if err != nil {
+		return GetResult{}, err
 	} else {
-		return v.(io.ReadCloser), err
+		return v.(GetResult), err
 	}
 }
-func (s SmartS3) GetObject(req GetRequest) ([]byte, error) {
+
+func (s SmartS3) GetObjectContext(ctx context.Context, req GetRequest) ([]byte, error) {
 	err := checkObject(req.Object)
 	if err != nil {
 		return nil, err
 	}
-	f := func() (interface{}, error) {
-		return getObject(s.Auth, req)
+	f := func(s SmartS3) (interface{}, error) {
+		return s.getObject(ctx, req)
 	}
-	v, err := s.retry(print(req), f)
+	v, err := s.retry(ctx, req.Object.Bucket, print(req), f)
 	if err != nil {
 		return nil, err
 	} else {
@@ -123,49 +520,183 @@ func (s SmartS3) GetObject(req GetRequest) ([]byte, error) {
 	}
 }
 
-func (s SmartS3) Put(req PutRequest) error {
+// fetches an object's attributes without its body; a missing object yields an error wrapping ErrNotFound
+func (s SmartS3) Head(req HeadRequest) (ObjectInfo, error) {
+	return s.HeadContext(context.Background(), req)
+}
+
+func (s SmartS3) HeadContext(ctx context.Context, req HeadRequest) (ObjectInfo, error) {
 	err := checkObject(req.Object)
 	if err != nil {
-		return err
+		return ObjectInfo{}, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		return s.head(ctx, req)
+	}
+	v, err := s.retry(ctx, req.Object.Bucket, print(req), f)
+	if err != nil {
+		return ObjectInfo{}, err
+	} else {
+		return v.(ObjectInfo), err
 	}
-	f := func() (interface{}, error) {
-		return nil, put(s.Auth, req)
+}
+
+// reports whether o is present: false for a 404, an error for any other failure (e.g. 403)
+func Exists(auth aws.Auth, o Object) (bool, error) {
+	return NewClient(auth).Exists(o)
+}
+
+func (s SmartS3) Exists(o Object) (bool, error) {
+	_, err := s.Head(HeadRequest{Object: o})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
 	}
-	_, err = s.retry(print(req), f)
+	return err == nil, err
+}
+
+func (s SmartS3) PutContext(ctx context.Context, req PutRequest) error {
+	_, err := s.PutWithResult(ctx, req)
 	return err
 }
 
-func (s SmartS3) PutObject(req PutObjectRequest) error {
+// like PutContext, but also returns the etag and version id s3 assigned
+func (s SmartS3) PutWithResult(ctx context.Context, req PutRequest) (PutResult, error) {
 	err := checkObject(req.Object)
+	if err == nil {
+		err = req.PutOptions.validate()
+	}
 	if err != nil {
-		return err
+		return PutResult{}, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		return s.put(ctx, req)
 	}
-	f := func() (interface{}, error) {
-		return nil, putObject(s.Auth, req)
+	v, err := s.retry(ctx, req.Object.Bucket, print(req), f)
+	if err != nil {
+		return PutResult{}, err
+	} else {
+		return v.(PutResult), err
 	}
-	_, err = s.retry(print(req), f)
+}
+
+// streams length bytes of r to o, such as from an open file. length must be exact:
+// s3 rejects a short body, and anything beyond length isn't sent. since r can only
+// be read once, the upload is never retried.
+func (s SmartS3) PutReader(ctx context.Context, o Object, r io.Reader, length int64, contentType string) (PutResult, error) {
+	if err := checkObject(o); err != nil {
+		return PutResult{}, err
+	}
+	if length < 0 {
+		return PutResult{}, fmt.Errorf("negative length %d", length)
+	}
+	return s.put(ctx, PutRequest{Object: o, ContentType: contentType, ReaderFact: onceReaderFact{r: r, n: length}})
+}
+
+// hands out the same reader, limited to n bytes, however many times it's asked.
+// put leaves GetBody unset for it, so a retry never sends what's been read.
+type onceReaderFact struct {
+	r io.Reader
+	n int64
+}
+
+func (f onceReaderFact) CreateReader() (io.ReadCloser, error) {
+	return io.NopCloser(io.LimitReader(f.r, f.n)), nil
+}
+
+func (f onceReaderFact) Len() uint64 {
+	return uint64(f.n)
+}
+
+func (s SmartS3) PutObjectContext(ctx context.Context, req PutObjectRequest) error {
+	_, err := s.PutObjectWithResult(ctx, req)
 	return err
 }
 
-func (s SmartS3) Delete(req DeleteRequest) error {
+// like PutObjectContext, but also returns the etag and version id s3 assigned
+func (s SmartS3) PutObjectWithResult(ctx context.Context, req PutObjectRequest) (PutResult, error) {
+	err := checkObject(req.Object)
+	if err == nil {
+		err = req.PutOptions.validate()
+	}
+	if err != nil {
+		return PutResult{}, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		return s.putObject(ctx, req)
+	}
+	v, err := s.retry(ctx, req.Object.Bucket, print(req), f)
+	if err != nil {
+		return PutResult{}, err
+	} else {
+		return v.(PutResult), err
+	}
+}
+
+func (s SmartS3) DeleteContext(ctx context.Context, req DeleteRequest) error {
 	err := checkObject(req.Object)
 	if err != nil {
 		return err
 	}
-	f := func() (interface{}, error) {
-		return nil, del(s.Auth, req)
+	f := func(s SmartS3) (interface{}, error) {
+		return nil, s.del(ctx, req)
 	}
-	_, err = s.retry(print(req), f)
+	_, err = s.retry(ctx, req.Object.Bucket, print(req), f)
 	return err
 }
 
-func (s SmartS3) retry(msg string, f func() (interface{}, error)) (v interface{}, err error) {
-	return goutil.Retry(msg, s.Strat.NewInstance(), f)
+// calls f, with the client to send through, until it succeeds or s.Strat
+// gives up. a redirect, or a complaint about our clock, is acted on straight
+// away, and what's lasting is remembered for later requests.
+func (s SmartS3) retry(ctx context.Context, bucket, msg string, f func(s SmartS3) (interface{}, error)) (v interface{}, err error) {
+	strat := s.Strat
+	if strat == nil {
+		strat = goutil.RetryBackoffStrat{}
+	}
+	r := &ctxRetry{ctx: ctx, inner: strat.NewInstance()}
+	return goutil.Retry(msg, r, func() (interface{}, error) {
+		v, err := f(s)
+		if err != nil {
+			if t, ok := s.followRedirect(bucket, err); ok {
+				v, err = f(t)
+			} else if t, ok := s.learnSkew(err); ok {
+				v, err = f(t)
+			}
+		}
+		var signing *SigningError
+		r.permanent = errors.As(err, &signing) || refused(err) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrNotModified) || errors.Is(err, ErrBucketAlreadyOwned) ||
+			errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrTooLarge) || errors.Is(err, ErrRangeIgnored) || errors.Is(err, ErrRestoreInProgress)
+		return v, err
+	})
+}
+
+// whether err is a refusal that asking again won't change: any 4xx but a
+// timeout or throttling
+func refused(err error) bool {
+	var e *S3Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch {
+	case e.HTTPStatusCode == 408, e.HTTPStatusCode == 429, e.Code == "RequestTimeout":
+		return false
+	}
+	return e.HTTPStatusCode >= 400 && e.HTTPStatusCode < 500
+}
+
+// stops retrying as soon as the context is done, or on errors that retrying can't fix
+type ctxRetry struct {
+	ctx       context.Context
+	inner     goutil.RetryStrategyInstance
+	permanent bool
+}
+
+func (r *ctxRetry) Retry() bool {
+	return !r.permanent && r.ctx.Err() == nil && r.inner.Retry()
 }
 
 func checkObject(o Object) error {
-	if o.Bucket == "" || o.Key == "" {
+	if o.Key == "" {
 		return errors.New("illegal bucket or key")
 	}
-	return nil
+	return ValidateBucketName(o.Bucket)
 }
@@ -0,0 +1,642 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil"
+	"github.com/xoba/goutil/aws"
+)
+
+const listXML = `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>bucket</Name>
+  <Prefix/>
+  <Marker/>
+  <MaxKeys>1000</MaxKeys>
+  <IsTruncated>false</IsTruncated>
+  <Contents>
+    <Key>my-image.jpg</Key>
+    <LastModified>2009-10-12T17:50:30.000Z</LastModified>
+    <ETag>&quot;fba9dede5f27731c9771645a39863328&quot;</ETag>
+    <Size>434234</Size>
+    <StorageClass>STANDARD</StorageClass>
+    <Owner>
+      <ID>75aa57f09aa0c8caeab4f8c24e99d10f8e7faeebf76c078efc7c6caea54ba06a</ID>
+      <DisplayName>mtd@amazon.com</DisplayName>
+    </Owner>
+  </Contents>
+</ListBucketResult>`
+
+func TestListParsesContents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, listXML)
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	l, err := s.ListContext(context.Background(), ListRequest{Bucket: "bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name != "bucket" || l.MaxKeys != 1000 || l.IsTruncated || len(l.Contents) != 1 {
+		t.Fatalf("got %+v", l)
+	}
+	c := l.Contents[0]
+	want := ListBucketResultContents{
+		Key:          "my-image.jpg",
+		ETag:         `"fba9dede5f27731c9771645a39863328"`,
+		StorageClass: "STANDARD",
+		Size:         434234,
+		Owner: ListBucketResultOwner{
+			ID:          "75aa57f09aa0c8caeab4f8c24e99d10f8e7faeebf76c078efc7c6caea54ba06a",
+			DisplayName: "mtd@amazon.com",
+		},
+		LastModified: time.Date(2009, 10, 12, 17, 50, 30, 0, time.UTC),
+	}
+	if c != want {
+		t.Errorf("got %+v\nwant %+v", c, want)
+	}
+}
+
+func TestListBrokenXML(t *testing.T) {
+	for _, body := range []string{listXML[:len(listXML)/2], "<ListBucketResult><Name>bucket</Name><Contents><Key>a</Key></Content>", "not xml"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}))
+		defer srv.Close()
+		s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+		if l, err := s.ListContext(context.Background(), ListRequest{Bucket: "bucket"}); err == nil {
+			t.Errorf("%q: got %+v, want an error", body, l)
+		}
+	}
+}
+
+func TestListBadURL(t *testing.T) {
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: "http://bad\x7fhost"}
+	if _, err := s.ListContext(context.Background(), ListRequest{Bucket: "bucket"}); err == nil {
+		t.Error("listed through an endpoint that isn't a url")
+	}
+}
+
+func TestListURLEncoded(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, `<ListBucketResult><Name>bucket</Name><Prefix>a%0A</Prefix><EncodingType>url</EncodingType>
+<Contents><Key>a%0Ab%3C%26</Key></Contents><CommonPrefixes><Prefix>a%0Ac/</Prefix></CommonPrefixes></ListBucketResult>`)
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	l, err := s.ListContext(context.Background(), ListRequest{Bucket: "bucket", Prefix: "a\n", EncodingType: "url"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("encoding-type") != "url" || query.Get("prefix") != "a\n" {
+		t.Errorf("sent %v", query)
+	}
+	if l.Prefix != "a\n" || len(l.Contents) != 1 || l.Contents[0].Key != "a\nb<&" || len(l.CommonPrefixes) != 1 || l.CommonPrefixes[0] != "a\nc/" {
+		t.Errorf("got %+v", l)
+	}
+}
+
+func TestListTruncatedWithoutNextMarker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<ListBucketResult><Name>bucket</Name><Delimiter>/</Delimiter><MaxKeys>2</MaxKeys><KeyCount>2</KeyCount><IsTruncated>true</IsTruncated>
+<Contents><Key>a</Key></Contents><CommonPrefixes><Prefix>b/</Prefix></CommonPrefixes></ListBucketResult>`)
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	l, err := s.ListContext(context.Background(), ListRequest{Bucket: "bucket", Delimiter: "/", MaxKeys: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.IsTruncated || l.NextMarker != "" || l.MaxKeys != 2 || l.KeyCount != 2 || l.Delimiter != "/" {
+		t.Errorf("got %+v", l)
+	}
+	if m := l.NextPageMarker(); m != "b/" {
+		t.Errorf("next page marker %q, want the last common prefix", m)
+	}
+}
+
+func TestGetRaw(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Request-Id", "4442587FB7D0A2F9")
+		w.Header().Set("X-Custom", "yes")
+		fmt.Fprint(w, "data")
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	resp, err := s.GetRaw(context.Background(), GetRequest{Object: Object{"bkt", "k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil || string(b) != "data" {
+		t.Errorf("body %q, %v", b, err)
+	}
+	if resp.Header.Get("X-Amz-Request-Id") != "4442587FB7D0A2F9" || resp.Header.Get("X-Custom") != "yes" {
+		t.Errorf("headers %v", resp.Header)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	var sent []string
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	s.HTTPClient = &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		sent = append(sent, r.Method+" "+r.URL.Path)
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	ctx := context.Background()
+	s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", "k"}, Data: []byte("x")})
+	s.GetObjectContext(ctx, GetRequest{Object: Object{"bkt", "k"}})
+	s.ListContext(ctx, ListRequest{Bucket: "bkt"})
+	s.DeleteContext(ctx, DeleteRequest{Object: Object{"bkt", "k"}})
+	if want := []string{"PUT /bkt/k", "GET /bkt/k", "GET /bkt/", "DELETE /bkt/k"}; fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("sent %v through the client, want %v", sent, want)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bkt/slow-body" {
+			w.Header().Set("Content-Length", "10")
+			w.Write([]byte("a"))
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, Timeout: 50 * time.Millisecond}
+	for _, key := range []string{"slow-headers", "slow-body"} {
+		start := time.Now()
+		_, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", key}})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v, want a timeout", key, err)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: took %v", key, d)
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bkt/here":
+		case "/bkt/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	for _, c := range []struct {
+		key   string
+		want  bool
+		error bool
+	}{
+		{"here", true, false},
+		{"gone", false, false},
+		{"forbidden", false, true},
+	} {
+		ok, err := s.Exists(Object{"bkt", c.key})
+		if ok != c.want || (err != nil) != c.error {
+			t.Errorf("%s: got %v, %v", c.key, ok, err)
+		}
+	}
+}
+
+// sends the requests of clients without an HTTPClient of their own to srv,
+// whatever host they're for, until the test ends
+func routeDefaultClient(t *testing.T, srv *httptest.Server) {
+	old := http.DefaultClient.Transport
+	http.DefaultClient.Transport = roundTripper(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = "http", srv.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultClient.Transport = old })
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestExistsDefaultClient(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/here"):
+		case strings.HasSuffix(r.URL.Path, "/forbidden"):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	routeDefaultClient(t, srv)
+	auth := aws.Auth{AccessKey: "a", SecretKey: "b"}
+	if ok, err := Exists(auth, Object{"bkt", "here"}); !ok || err != nil {
+		t.Errorf("here: got %v, %v", ok, err)
+	}
+	if ok, err := Exists(auth, Object{"bkt", "gone"}); ok || err != nil {
+		t.Errorf("gone: got %v, %v", ok, err)
+	}
+	hits = 0
+	start := time.Now()
+	ok, err := Exists(auth, Object{"bkt", "forbidden"})
+	var e *S3Error
+	if ok || !errors.As(err, &e) || e.HTTPStatusCode != http.StatusForbidden {
+		t.Errorf("forbidden: got %v, %v", ok, err)
+	}
+	if hits != 1 || time.Since(start) > time.Second {
+		t.Errorf("a 403 was asked %d times, over %v", hits, time.Since(start))
+	}
+}
+
+func TestPutContentLength(t *testing.T) {
+	var lengths [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lengths = append(lengths, r.Header.Values("Content-Length"))
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	data := make([]byte, 10240)
+	if err := s.Put(PutRequest{Object: Object{"bkt", "k"}, ReaderFact: goutil.BufferReaderFact{Buffer: data}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutObject(PutObjectRequest{Object: Object{"bkt", "k"}, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	if len(lengths) != 2 {
+		t.Fatalf("%d requests", len(lengths))
+	}
+	for _, l := range lengths {
+		if len(l) != 1 || l[0] != "10240" {
+			t.Errorf("got Content-Length %q", l)
+		}
+	}
+}
+
+func TestGetCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2048")
+		w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := s.GetContext(ctx, GetRequest{Object: Object{"bkt", "k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadFull(r, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = io.ReadAll(r)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v to notice the cancellation", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = s.GetObjectContext(ctx, GetRequest{Object: Object{"bkt", "k"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v to notice the cancellation", d)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	res, err := s.PutObjectWithResult(ctx, PutObjectRequest{Object: o, Data: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: o, IfNoneMatch: res.ETag}); !errors.Is(err, ErrNotModified) {
+		t.Errorf("matching etag: got %v", err)
+	}
+	if b, err := s.GetObjectContext(ctx, GetRequest{Object: o, IfNoneMatch: `"other"`}); err != nil || string(b) != "x" {
+		t.Errorf("other etag: got %q, %v", b, err)
+	}
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: o, IfModifiedSince: time.Now().Add(time.Hour)}); !errors.Is(err, ErrNotModified) {
+		t.Errorf("not modified since: got %v", err)
+	}
+	if b, err := s.GetObjectContext(ctx, GetRequest{Object: o, IfModifiedSince: time.Now().Add(-time.Hour)}); err != nil || string(b) != "x" {
+		t.Errorf("modified since: got %q, %v", b, err)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: make([]byte, 100)}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.GetObjectContext(ctx, GetRequest{Object: o, MaxBytes: 100}); err != nil || len(b) != 100 {
+		t.Errorf("at the limit: got %d bytes, %v", len(b), err)
+	}
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: o, MaxBytes: 99}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("over the limit: got %v", err)
+	}
+
+	// without a content length, only reading tells
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 50))
+		w.(http.Flusher).Flush()
+		w.Write(make([]byte, 50))
+	}))
+	defer srv.Close()
+	s.Endpoint = srv.URL
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: o, MaxBytes: 99}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("streamed over the limit: got %v", err)
+	}
+}
+
+// a server recording the headers of each request, and answering every one
+// with reply's
+type headerServer struct {
+	mu    sync.Mutex
+	got   []http.Header
+	reply http.Header
+}
+
+func (h *headerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	io.Copy(io.Discard, r.Body)
+	h.got = append(h.got, r.Header)
+	for k, v := range h.reply {
+		w.Header()[k] = v
+	}
+}
+
+// the headers of the last request
+func (h *headerServer) last() http.Header {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.got[len(h.got)-1]
+}
+
+func newHeaderClient(t *testing.T) (*headerServer, SmartS3) {
+	h := &headerServer{reply: make(http.Header)}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+}
+
+func TestContentMD5(t *testing.T) {
+	h, s := newHeaderClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	// the md5 of "hello", base64 encoded
+	const want = "XUFAKrxLKna5cZ2REBfFkg=="
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: []byte("hello"), ContentMD5: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.last().Get("Content-Md5"); got != want {
+		t.Errorf("PutObject: got %q", got)
+	}
+	if err := s.PutContext(ctx, PutRequest{Object: o, ReaderFact: BytesReaderFact([]byte("hello")), ContentMD5: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.last().Get("Content-Md5"); got != want {
+		t.Errorf("Put: got %q", got)
+	}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.last().Get("Content-Md5"); got != "" {
+		t.Errorf("unasked for: got %q", got)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	req := PutObjectRequest{Object: o, Data: []byte("x")}
+	req.Metadata = map[string]string{"Color": "red", "owner-id": "42"}
+	if err := s.PutObjectContext(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Head(HeadRequest{Object: o})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.GetWithResult(ctx, GetRequest{Object: o})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for name, m := range map[string]map[string]string{"head": info.Metadata, "get": res.Metadata} {
+		if fmt.Sprint(m) != "map[color:red owner-id:42]" {
+			t.Errorf("%s: got %v", name, m)
+		}
+	}
+}
+
+func TestCannedACL(t *testing.T) {
+	h, s := newHeaderClient(t)
+	ctx := context.Background()
+	req := PutObjectRequest{Object: Object{"bkt", "k"}}
+	req.ACL = "public-read"
+	if err := s.PutObjectContext(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.last().Get("X-Amz-Acl"); got != "public-read" {
+		t.Errorf("got %q", got)
+	}
+	req.ACL = "public-everything"
+	if err := s.PutObjectContext(ctx, req); err == nil {
+		t.Error("no error for an unknown acl")
+	}
+	if len(h.got) != 1 {
+		t.Errorf("%d requests, want the bad one not sent", len(h.got))
+	}
+}
+
+func TestServerSideEncryption(t *testing.T) {
+	h, s := newHeaderClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	h.reply.Set("X-Amz-Server-Side-Encryption", SSEKMS)
+	h.reply.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", "key-1")
+	req := PutObjectRequest{Object: o}
+	req.ServerSideEncryption, req.KMSKeyID = SSEKMS, "key-1"
+	res, err := s.PutObjectWithResult(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.last(); got.Get("X-Amz-Server-Side-Encryption") != SSEKMS || got.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "key-1" {
+		t.Errorf("sent %v", got)
+	}
+	if res.ServerSideEncryption != SSEKMS || res.KMSKeyID != "key-1" {
+		t.Errorf("put: got %+v", res)
+	}
+	if info, err := s.Head(HeadRequest{Object: o}); err != nil || info.ServerSideEncryption != SSEKMS || info.KMSKeyID != "key-1" {
+		t.Errorf("head: got %+v, %v", info, err)
+	}
+	for _, bad := range []PutOptions{{ServerSideEncryption: "rot13"}, {ServerSideEncryption: SSES3, KMSKeyID: "key-1"}} {
+		if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, PutOptions: bad}); err == nil {
+			t.Errorf("no error for %+v", bad)
+		}
+	}
+}
+
+func TestStorageClass(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	req := PutObjectRequest{Object: o}
+	req.StorageClass = "STANDARD_IA"
+	if err := s.PutObjectContext(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Head(HeadRequest{Object: o}); err != nil || info.StorageClass != "STANDARD_IA" {
+		t.Errorf("got %q, %v", info.StorageClass, err)
+	}
+	req.StorageClass = "COLD"
+	if err := s.PutObjectContext(ctx, req); err == nil {
+		t.Error("no error for an unknown storage class")
+	}
+}
+
+func TestCachingHeaders(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "report.pdf"}
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	req := PutObjectRequest{Object: o}
+	req.CacheControl = "max-age=3600"
+	req.ContentDisposition = `attachment; filename="report.pdf"`
+	req.Expires = expires
+	if err := s.PutObjectContext(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Head(HeadRequest{Object: o})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CacheControl != req.CacheControl || info.ContentDisposition != req.ContentDisposition || !info.Expires.Equal(expires) {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestRequesterPays(t *testing.T) {
+	h, s := newHeaderClient(t)
+	s.RequesterPays = true
+	var signed []string
+	s.Trace = func(e TraceEvent) {
+		if e.StringToSign != "" {
+			signed = append(signed, e.StringToSign)
+		}
+	}
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: o}); err != nil {
+		t.Fatal(err)
+	}
+	for i, got := range h.got {
+		if got.Get("X-Amz-Request-Payer") != "requester" {
+			t.Errorf("request %d: sent %v", i, got)
+		}
+	}
+	for _, toSign := range signed {
+		if !strings.Contains(toSign, "\nx-amz-request-payer:requester\n") {
+			t.Errorf("not signed: %q", toSign)
+		}
+	}
+}
+
+func TestWebsiteRedirectLocation(t *testing.T) {
+	s := newMockClient(t)
+	o := Object{"bkt", "old/page.html"}
+	req := PutObjectRequest{Object: o}
+	req.WebsiteRedirectLocation = "/new/page.html"
+	if err := s.PutObjectContext(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Head(HeadRequest{Object: o}); err != nil || info.WebsiteRedirectLocation != "/new/page.html" {
+		t.Errorf("got %q, %v", info.WebsiteRedirectLocation, err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	h, s := newHeaderClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	// the sha256 of "hello", base64 encoded
+	const sum = "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	req := PutObjectRequest{Object: o, Data: []byte("hello")}
+	req.ChecksumAlgorithm = ChecksumSHA256
+	if err := s.PutObjectContext(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.last().Get("X-Amz-Checksum-Sha256"); got != sum {
+		t.Errorf("sent %q", got)
+	}
+	req.Checksum = "AAAA"
+	if err := s.PutObjectContext(ctx, req); err == nil {
+		t.Error("no error for a checksum the body doesn't have")
+	}
+	if len(h.got) != 1 {
+		t.Errorf("%d requests, want the mismatched one not sent", len(h.got))
+	}
+	for _, bad := range []PutOptions{{ChecksumAlgorithm: "MD4"}, {Checksum: sum}} {
+		if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, PutOptions: bad}); err == nil {
+			t.Errorf("no error for %+v", bad)
+		}
+	}
+
+	h.reply.Set("X-Amz-Checksum-Sha256", sum)
+	info, err := s.Head(HeadRequest{Object: o, Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.last().Get("X-Amz-Checksum-Mode") != "ENABLED" || info.ChecksumSHA256 != sum {
+		t.Errorf("head: sent %v, got %+v", h.last(), info)
+	}
+}
@@ -0,0 +1,194 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// an s3 select query over a csv object, whose matching rows come back as json
+type SelectRequest struct {
+	// e.g. "SELECT s.name FROM S3Object s WHERE s.age > '30'"
+	Expression string
+	// "USE" to name columns by the first line, "IGNORE" to skip it, or "NONE"
+	// (the default) when there's no header line
+	FileHeaderInfo string
+	// "," when empty
+	FieldDelimiter string
+	// "NONE" (the default), "GZIP" or "BZIP2"
+	CompressionType string
+}
+
+type selectObjectContentRequest struct {
+	XMLName             xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ SelectObjectContentRequest"`
+	Expression          string
+	ExpressionType      string
+	InputSerialization  selectInput
+	OutputSerialization selectOutput
+}
+
+type selectInput struct {
+	CSV struct {
+		FileHeaderInfo string `xml:",omitempty"`
+		FieldDelimiter string `xml:",omitempty"`
+	}
+	CompressionType string `xml:",omitempty"`
+}
+
+type selectOutput struct {
+	JSON struct {
+		RecordDelimiter string
+	}
+}
+
+// runs the query against o, returning one json object per matching row
+func (s SmartS3) Select(ctx context.Context, o Object, req SelectRequest) ([]json.RawMessage, error) {
+	if err := checkObject(o); err != nil {
+		return nil, err
+	}
+	if req.Expression == "" {
+		return nil, errors.New("no select expression")
+	}
+	doc := selectObjectContentRequest{Expression: req.Expression, ExpressionType: "SQL"}
+	doc.InputSerialization.CSV.FileHeaderInfo = req.FileHeaderInfo
+	doc.InputSerialization.CSV.FieldDelimiter = req.FieldDelimiter
+	doc.InputSerialization.CompressionType = req.CompressionType
+	doc.OutputSerialization.JSON.RecordDelimiter = "\n"
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	u, resource, err := s.subresourceURL(o, url.Values{"select": {""}, "select-type": {"2"}})
+	if err != nil {
+		return nil, err
+	}
+	h := http.Header{"Content-Type": {"application/xml"}}
+	f := func(s SmartS3) (interface{}, error) {
+		resp, err := s.do(ctx, "POST", u, resource, h, body)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return selectRecords(resp.Body)
+	}
+	v, err := s.retry(ctx, o.Bucket, fmt.Sprintf("select from %s", print(o)), f)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]json.RawMessage), nil
+}
+
+// decodes an event stream, gathering the payloads of its Records events into
+// newline-delimited records, until the End event
+func selectRecords(r io.Reader) ([]json.RawMessage, error) {
+	var records bytes.Buffer
+	for {
+		headers, payload, err := readEvent(r)
+		if err != nil {
+			return nil, err
+		}
+		switch headers[":message-type"] {
+		case "error":
+			return nil, &S3Error{Code: headers[":error-code"], Message: headers[":error-message"], Status: "select failed"}
+		case "event":
+		default:
+			return nil, fmt.Errorf("unknown event stream message type %q", headers[":message-type"])
+		}
+		switch headers[":event-type"] {
+		case "Records":
+			records.Write(payload)
+		case "End":
+			var out []json.RawMessage
+			for _, line := range bytes.Split(records.Bytes(), []byte("\n")) {
+				if len(bytes.TrimSpace(line)) > 0 {
+					out = append(out, json.RawMessage(line))
+				}
+			}
+			return out, nil
+		}
+	}
+}
+
+// reads one message of the aws event stream encoding: a prelude of total and
+// header lengths with its own crc, the headers, the payload, and a crc of it all.
+// only string-valued headers are kept.
+func readEvent(r io.Reader) (headers map[string]string, payload []byte, err error) {
+	var prelude [12]byte
+	if _, err := io.ReadFull(r, prelude[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, fmt.Errorf("event stream ended without an End event: %w", err)
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	hlen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, nil, errors.New("event stream prelude checksum mismatch")
+	}
+	if total < 16 || total-16 < hlen || total > 16<<20 {
+		return nil, nil, fmt.Errorf("bad event stream message length %d", total)
+	}
+	msg := make([]byte, total)
+	copy(msg, prelude[:])
+	if _, err := io.ReadFull(r, msg[12:]); err != nil {
+		return nil, nil, err
+	}
+	if crc32.ChecksumIEEE(msg[:total-4]) != binary.BigEndian.Uint32(msg[total-4:]) {
+		return nil, nil, errors.New("event stream message checksum mismatch")
+	}
+	headers = make(map[string]string)
+	for h := msg[12 : 12+hlen]; len(h) > 0; {
+		n := int(h[0])
+		if len(h) < 1+n+1 {
+			return nil, nil, errors.New("truncated event stream header")
+		}
+		name, typ := string(h[1:1+n]), h[1+n]
+		h = h[2+n:]
+		size, err := headerValueSize(typ, h)
+		if err != nil {
+			return nil, nil, err
+		}
+		if typ == 7 {
+			headers[name] = string(h[2:size])
+		}
+		h = h[size:]
+	}
+	return headers, msg[12+hlen : total-4], nil
+}
+
+// the length of an encoded header value of type typ at the start of h
+func headerValueSize(typ byte, h []byte) (int, error) {
+	var n int
+	switch typ {
+	case 0, 1: // true, false
+	case 2: // byte
+		n = 1
+	case 3: // short
+		n = 2
+	case 4: // int
+		n = 4
+	case 5, 8: // long, timestamp
+		n = 8
+	case 6, 7: // bytes, string, with a two-byte length
+		if len(h) < 2 {
+			return 0, errors.New("truncated event stream header")
+		}
+		n = 2 + int(binary.BigEndian.Uint16(h))
+	case 9: // uuid
+		n = 16
+	default:
+		return 0, fmt.Errorf("unknown event stream header type %d", typ)
+	}
+	if len(h) < n {
+		return 0, errors.New("truncated event stream header")
+	}
+	return n, nil
+}
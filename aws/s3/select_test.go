@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// one event stream message with string headers, given as name, value pairs,
+// plus a timestamp header to be skipped
+func eventMessage(payload string, headers ...string) []byte {
+	var h bytes.Buffer
+	for i := 0; i < len(headers); i += 2 {
+		h.WriteByte(byte(len(headers[i])))
+		h.WriteString(headers[i])
+		h.WriteByte(7)
+		binary.Write(&h, binary.BigEndian, uint16(len(headers[i+1])))
+		h.WriteString(headers[i+1])
+	}
+	h.WriteByte(byte(len(":date")))
+	h.WriteString(":date")
+	h.WriteByte(8)
+	binary.Write(&h, binary.BigEndian, int64(1577934245000))
+
+	var m bytes.Buffer
+	binary.Write(&m, binary.BigEndian, uint32(16+h.Len()+len(payload)))
+	binary.Write(&m, binary.BigEndian, uint32(h.Len()))
+	binary.Write(&m, binary.BigEndian, crc32.ChecksumIEEE(m.Bytes()))
+	m.Write(h.Bytes())
+	m.WriteString(payload)
+	binary.Write(&m, binary.BigEndian, crc32.ChecksumIEEE(m.Bytes()))
+	return m.Bytes()
+}
+
+func records(payload string) []byte {
+	return eventMessage(payload, ":message-type", "event", ":event-type", "Records")
+}
+
+var endEvent = eventMessage("", ":message-type", "event", ":event-type", "End")
+
+func TestSelect(t *testing.T) {
+	var stream []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.RawQuery != "select&select-type=2" || !strings.Contains(string(b), "<Expression>SELECT * FROM S3Object</Expression>") {
+			t.Errorf("got %s %s: %s", r.Method, r.URL, b)
+		}
+		w.Write(stream)
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	o := Object{"bkt", "people.csv"}
+	req := SelectRequest{Expression: "SELECT * FROM S3Object", FileHeaderInfo: "USE"}
+
+	// a record split across events, a stats event, and the end
+	stream = bytes.Join([][]byte{
+		records(`{"name":"a"}` + "\n" + `{"na`),
+		records(`me":"b"}` + "\n"),
+		eventMessage("<Stats/>", ":message-type", "event", ":event-type", "Stats"),
+		endEvent,
+	}, nil)
+	got, err := s.Select(context.Background(), o, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%s", got) != `[{"name":"a"} {"name":"b"}]` {
+		t.Errorf("got %s", got)
+	}
+
+	stream = eventMessage("", ":message-type", "error", ":error-code", "InvalidQuery", ":error-message", "bad sql")
+	if _, err := s.Select(context.Background(), o, req); err == nil || !strings.Contains(err.Error(), "InvalidQuery") {
+		t.Errorf("error event: got %v", err)
+	}
+
+	stream = records(`{"name":"a"}` + "\n")
+	if _, err := s.Select(context.Background(), o, req); err == nil {
+		t.Error("no error for a stream without an End event")
+	}
+
+	stream = append(records(`{}`), endEvent...)
+	stream[len(stream)-len(endEvent)-1] ^= 1
+	if _, err := s.Select(context.Background(), o, req); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("corrupt message: got %v", err)
+	}
+}
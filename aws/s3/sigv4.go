@@ -0,0 +1,45 @@
+package s3
+
+// signature version 4 for s3, as described in
+// http://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html,
+// by way of package aws4
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/xoba/goutil/aws"
+	"github.com/xoba/goutil/aws4"
+	"net/http"
+	"time"
+)
+
+const (
+	iso8601BasicFormat = "20060102T150405Z"
+	// hex sha256 of an empty body
+	emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// sent for streaming bodies we can't hash ahead of time
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+func payloadHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// adds x-amz-date, x-amz-content-sha256 and a v4 authorization header to hreq.
+// every header already on the request is signed, so set them all beforehand.
+func signV4(a aws.Auth, hreq *http.Request, payload string, t time.Time) (toSign string, err error) {
+	t = t.UTC()
+	hreq.Header.Set("X-Amz-Date", t.Format(iso8601BasicFormat))
+	hreq.Header.Set("X-Amz-Content-Sha256", payload)
+	if a.SecretKey == "" {
+		return "", &SigningError{Err: errNoSecretKey}
+	}
+	svc := aws4.Service{Name: "s3", Region: a.GetRegion()}
+	return svc.SignHashed(&aws4.Keys{AccessKey: a.AccessKey, SecretKey: a.SecretKey}, hreq, payload, t)
+}
+
+// rfc 3986 encoding, as aws requires: everything but unreserved characters
+func uriEncode(s string) string {
+	return aws4.URIEncode(s)
+}
@@ -0,0 +1,226 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// what a sync did, file by file
+type SyncResult struct {
+	Created, Updated, Skipped int
+}
+
+// uploads the files under localDir to bucket, each keyed by prefix plus its
+// slash-separated path relative to localDir. files whose remote copy already
+// matches, by md5 or, for multipart uploads whose etag isn't one, by size,
+// are skipped. DefaultConcurrency files are sent at a time.
+func (s SmartS3) SyncUp(ctx context.Context, localDir, bucket, prefix string) (out SyncResult, err error) {
+	type file struct {
+		path string
+		o    Object
+		size int64
+	}
+	var files []file
+	err = filepath.WalkDir(localDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{path, Object{bucket, syncKey(prefix, rel)}, info.Size()})
+		return nil
+	})
+	if err != nil {
+		return out, err
+	}
+	p := newPool(ctx, DefaultConcurrency)
+	var mu sync.Mutex
+	for _, f := range files {
+		f := f
+		p.Go(func(ctx context.Context) error {
+			counter, err := s.syncUpFile(ctx, f.path, f.o, f.size, &out)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			*counter++
+			mu.Unlock()
+			return nil
+		})
+	}
+	return out, p.Wait()
+}
+
+// uploads path to o unless it's already there, returning the count in out
+// that the outcome belongs to
+func (s SmartS3) syncUpFile(ctx context.Context, path string, o Object, size int64, out *SyncResult) (*int, error) {
+	counter := &out.Updated
+	info, err := s.HeadContext(ctx, HeadRequest{Object: o})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		counter = &out.Created
+	case err != nil:
+		return nil, err
+	case info.ContentLength == size:
+		same, err := sameContent(path, info.ETag)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			return &out.Skipped, nil
+		}
+	}
+	_, err = s.UploadFile(ctx, o, path, UploadOptions{})
+	return counter, err
+}
+
+// whether the file at path has the content etag describes. a multipart etag
+// isn't an md5 of the content, so then a matching size has to do.
+func sameContent(path, etag string) (bool, error) {
+	etag = strings.Trim(etag, `"`)
+	if strings.Contains(etag, "-") {
+		return true, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == etag, nil
+}
+
+// the key for a file at rel, an os-specific path relative to the synced directory
+func syncKey(prefix, rel string) string {
+	rel = filepath.ToSlash(rel)
+	if prefix == "" {
+		return rel
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + rel
+}
+
+// writes the keys under prefix in bucket to files at their paths relative to
+// prefix under localDir, making directories as needed. files whose size and
+// modification time match the object's are skipped; downloaded files are
+// given the object's modification time, so an unchanged object is skipped
+// next time. DefaultConcurrency objects are fetched at a time.
+func (s SmartS3) SyncDown(ctx context.Context, bucket, prefix, localDir string) (out SyncResult, err error) {
+	p := newPool(ctx, DefaultConcurrency)
+	var mu sync.Mutex
+	it := s.ListIterContext(p.ctx, ListRequest{Bucket: bucket, Prefix: prefix})
+	for {
+		c, ok := it.Next()
+		if !ok {
+			break
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(c.Key, prefix), "/")
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			// the prefix itself, or an empty "folder" object
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			p.fail(fmt.Errorf("key %q would be written outside %s", c.Key, localDir))
+			break
+		}
+		path := filepath.Join(localDir, filepath.FromSlash(rel))
+		p.Go(func(ctx context.Context) error {
+			counter := &out.Created
+			if info, err := os.Stat(path); err == nil {
+				if info.Size() == int64(c.Size) && info.ModTime().Equal(c.LastModified) {
+					mu.Lock()
+					out.Skipped++
+					mu.Unlock()
+					return nil
+				}
+				counter = &out.Updated
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := s.GetToFile(ctx, Object{bucket, c.Key}, path, DownloadOptions{Atomic: true}); err != nil {
+				return err
+			}
+			if err := os.Chtimes(path, c.LastModified, c.LastModified); err != nil {
+				return err
+			}
+			mu.Lock()
+			*counter++
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := it.Err(); err != nil {
+		p.fail(err)
+	}
+	return out, p.Wait()
+}
+
+// runs functions concurrently, a bounded number at a time, until one fails
+type pool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	sem    chan struct{}
+	mu     sync.Mutex
+	err    error
+}
+
+func newPool(ctx context.Context, n int) *pool {
+	ctx, cancel := context.WithCancel(ctx)
+	return &pool{ctx: ctx, cancel: cancel, sem: make(chan struct{}, n)}
+}
+
+// runs f once there's room, unless the pool has already failed
+func (p *pool) Go(f func(ctx context.Context) error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-p.ctx.Done():
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.sem }()
+		if err := f(p.ctx); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+// stops the pool, keeping the first error
+func (p *pool) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+		p.cancel()
+	}
+}
+
+// waits for everything started, returning the first error or the context's
+func (p *pool) Wait() error {
+	p.wg.Wait()
+	defer p.cancel()
+	if p.err != nil {
+		return p.err
+	}
+	return p.ctx.Err()
+}
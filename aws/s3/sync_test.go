@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writes files, keyed by slash-separated path, under dir
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncUp(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/deeper/c": "c"})
+	res, err := s.SyncUp(ctx, dir, "bkt", "up/")
+	if err != nil || res != (SyncResult{Created: 3}) {
+		t.Fatalf("first: got %+v, %v", res, err)
+	}
+	if b, err := s.GetObjectContext(ctx, GetRequest{Object: Object{"bkt", "up/sub/deeper/c"}}); err != nil || string(b) != "c" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	writeTree(t, dir, map[string]string{"a.txt": "A"})
+	res, err = s.SyncUp(ctx, dir, "bkt", "up")
+	if err != nil || res != (SyncResult{Updated: 1, Skipped: 2}) {
+		t.Errorf("second: got %+v, %v", res, err)
+	}
+}
+
+func TestSyncDown(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	for _, k := range []string{"down/a.txt", "down/sub/b.txt", "down/folder/", "elsewhere"} {
+		if err := s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", k}, Data: []byte(k)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	res, err := s.SyncDown(ctx, "bkt", "down/", dir)
+	if err != nil || res != (SyncResult{Created: 2}) {
+		t.Fatalf("first: got %+v, %v", res, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "sub", "b.txt")); err != nil || string(b) != "down/sub/b.txt" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	res, err = s.SyncDown(ctx, "bkt", "down/", dir)
+	if err != nil || res != (SyncResult{Skipped: 2}) {
+		t.Errorf("second: got %+v, %v", res, err)
+	}
+
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: Object{"bkt", "down/../escape"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SyncDown(ctx, "bkt", "down/", dir); err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("key outside the directory: got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"unicode/utf8"
+)
+
+// limits s3 places on the tags of one object
+const (
+	MaxTags           = 10
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 256
+)
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Tags    []tag    `xml:"TagSet>Tag"`
+}
+
+type tag struct {
+	Key, Value string
+}
+
+// replaces the tags on o with tags
+func (s SmartS3) PutObjectTagging(ctx context.Context, o Object, tags map[string]string) error {
+	if err := checkObject(o); err != nil {
+		return err
+	}
+	if err := checkTags(tags); err != nil {
+		return err
+	}
+	var doc tagging
+	for k, v := range tags {
+		doc.Tags = append(doc.Tags, tag{k, v})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Key < doc.Tags[j].Key })
+	return s.putSubresource(ctx, o, "tagging", doc)
+}
+
+// the tags on o, empty if it has none
+func (s SmartS3) GetObjectTagging(ctx context.Context, o Object) (map[string]string, error) {
+	if err := checkObject(o); err != nil {
+		return nil, err
+	}
+	u, resource, err := s.subresourceURL(o, url.Values{"tagging": {""}})
+	if err != nil {
+		return nil, err
+	}
+	f := func(s SmartS3) (interface{}, error) {
+		var doc tagging
+		err := s.doXML(ctx, "GET", u, resource, nil, nil, &doc)
+		return doc, err
+	}
+	v, err := s.retry(ctx, o.Bucket, fmt.Sprintf("get tagging %v", o), f)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, t := range v.(tagging).Tags {
+		out[t.Key] = t.Value
+	}
+	return out, nil
+}
+
+func checkTags(tags map[string]string) error {
+	if len(tags) > MaxTags {
+		return fmt.Errorf("%d tags, at most %d allowed", len(tags), MaxTags)
+	}
+	for k, v := range tags {
+		switch {
+		case k == "":
+			return errors.New("empty tag key")
+		case utf8.RuneCountInString(k) > MaxTagKeyLength:
+			return fmt.Errorf("tag key %q longer than %d characters", k, MaxTagKeyLength)
+		case utf8.RuneCountInString(v) > MaxTagValueLength:
+			return fmt.Errorf("value of tag %q longer than %d characters", k, MaxTagValueLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// a server keeping the documents put to an object's subresources, e.g.
+// ?tagging, and serving them back
+type subresourceServer struct {
+	mu   sync.Mutex
+	docs map[string]string
+}
+
+func (s *subresourceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := r.URL.Path + "?" + r.URL.RawQuery
+	switch r.Method {
+	case "PUT":
+		if r.Header.Get("Content-Md5") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		s.docs[name] = string(b)
+	case "GET":
+		io.WriteString(w, s.docs[name])
+	}
+}
+
+func newSubresourceClient(t *testing.T) (*subresourceServer, SmartS3) {
+	sub := &subresourceServer{docs: make(map[string]string)}
+	srv := httptest.NewServer(sub)
+	t.Cleanup(srv.Close)
+	return sub, SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+}
+
+func TestObjectTagging(t *testing.T) {
+	sub, s := newSubresourceClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if err := s.PutObjectTagging(ctx, o, map[string]string{"project": "x", "env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<Tagging><TagSet><Tag><Key>env</Key><Value>prod</Value></Tag><Tag><Key>project</Key><Value>x</Value></Tag></TagSet></Tagging>"; sub.docs["/bkt/k?tagging"] != want {
+		t.Errorf("sent %s\nwant %s", sub.docs["/bkt/k?tagging"], want)
+	}
+	got, err := s.GetObjectTagging(ctx, o)
+	if err != nil || fmt.Sprint(got) != "map[env:prod project:x]" {
+		t.Errorf("got %v, %v", got, err)
+	}
+
+	tooMany := make(map[string]string)
+	for i := 0; i <= MaxTags; i++ {
+		tooMany[fmt.Sprint(i)] = ""
+	}
+	for _, tags := range []map[string]string{
+		tooMany,
+		{"": "v"},
+		{strings.Repeat("k", MaxTagKeyLength+1): "v"},
+		{"k": strings.Repeat("v", MaxTagValueLength+1)},
+	} {
+		if err := s.PutObjectTagging(ctx, o, tags); err == nil {
+			t.Errorf("put %d tags that s3 would refuse", len(tags))
+		}
+	}
+}
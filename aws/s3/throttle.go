@@ -0,0 +1,39 @@
+package s3
+
+import (
+	"io"
+	"time"
+)
+
+// limits reads to rate bytes a second on average, sleeping as needed
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+// r limited to s.BytesPerSecond, or r itself when there's no limit
+func (s SmartS3) throttle(r io.Reader) io.Reader {
+	if s.BytesPerSecond <= 0 {
+		return r
+	}
+	return &throttledReader{r: r, rate: s.BytesPerSecond}
+}
+
+func (t *throttledReader) Read(b []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// read in slices of a tenth of a second, so the rate is smooth rather than bursty
+	if max := t.rate / 10; max > 0 && int64(len(b)) > max {
+		b = b[:max]
+	}
+	n, err := t.r.Read(b)
+	t.n += int64(n)
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+	return n, err
+}
@@ -0,0 +1,34 @@
+package s3
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBytesPerSecond(t *testing.T) {
+	s := newMockClient(t)
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	data := make([]byte, 30<<10)
+	if err := s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	s.BytesPerSecond = 100 << 10
+	for _, op := range []struct {
+		name string
+		f    func() error
+	}{
+		{"put", func() error { return s.PutObjectContext(ctx, PutObjectRequest{Object: o, Data: data}) }},
+		{"get", func() error { _, err := s.GetObjectContext(ctx, GetRequest{Object: o}); return err }},
+	} {
+		start := time.Now()
+		if err := op.f(); err != nil {
+			t.Fatal(err)
+		}
+		// 30k at 100k a second
+		if d := time.Since(start); d < 250*time.Millisecond {
+			t.Errorf("%s took only %v", op.name, d)
+		}
+	}
+}
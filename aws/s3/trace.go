@@ -0,0 +1,46 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+)
+
+// a step in making a request, as reported to SmartS3.Trace. credentials
+// never appear in one: the session token a v2 string to sign includes is
+// replaced with "[redacted]".
+type TraceEvent struct {
+	Method, URL string
+	// what was signed, on the event for a request just signed
+	StringToSign string
+	// on the event for a response: its status, or why there was none
+	StatusCode int
+	Err        error
+	// also on the event for a response, failed or not
+	IDs RequestIDs
+}
+
+// toSign with any x-amz-security-token value in it redacted, fit to show
+func redactToken(toSign, token string) string {
+	if token == "" {
+		return toSign
+	}
+	return strings.Replace(toSign, "x-amz-security-token:"+token, "x-amz-security-token:[redacted]", -1)
+}
+
+func (s SmartS3) trace(e TraceEvent) {
+	if s.Trace != nil {
+		s.Trace(e)
+	}
+}
+
+func (s SmartS3) traceResponse(hreq *http.Request, resp *http.Response, err error) {
+	if s.Trace == nil {
+		return
+	}
+	e := TraceEvent{Method: hreq.Method, URL: hreq.URL.String(), Err: err}
+	if resp != nil {
+		e.StatusCode = resp.StatusCode
+		e.IDs = requestIDs(resp.Header)
+	}
+	s.Trace(e)
+}
@@ -0,0 +1,53 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestTraceStringToSign(t *testing.T) {
+	s := newMockClient(t)
+	s.Now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	s.Auth.SessionToken = "secret-token"
+	var events []TraceEvent
+	s.Trace = func(e TraceEvent) { events = append(events, e) }
+	if err := s.PutObjectContext(context.Background(), PutObjectRequest{Object: Object{"bkt", "k"}, Data: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("%d events, want a signing and a response event for each request", len(events))
+	}
+	get := events[2]
+	want := "GET\n\n\nThu, 02 Jan 2020 03:04:05 +0000\nx-amz-security-token:[redacted]\n/bkt/k"
+	if get.Method != "GET" || get.StringToSign != want {
+		t.Errorf("got %q, want %q", get.StringToSign, want)
+	}
+	if events[3].StatusCode != 200 {
+		t.Errorf("response event %+v", events[3])
+	}
+	for _, e := range events {
+		if strings.Contains(e.StringToSign+e.URL, "secret") {
+			t.Errorf("credentials in %+v", e)
+		}
+	}
+}
+
+func TestSigningErrorRedacted(t *testing.T) {
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SessionToken: "secret-token"}, Endpoint: "http://127.0.0.1:1"}
+	_, err := s.GetObjectContext(context.Background(), GetRequest{Object: Object{"bkt", "k"}})
+	var e *SigningError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want a SigningError", err)
+	}
+	if !strings.Contains(e.StringToSign, "[redacted]") || strings.Contains(e.StringToSign, "secret") {
+		t.Errorf("got %q", e.StringToSign)
+	}
+}
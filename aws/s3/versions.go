@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// one version of an object in a versioned bucket
+type ObjectVersion struct {
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+// a version recording that the object was deleted; while it's the latest, a
+// plain get of the key finds nothing
+type DeleteMarker struct {
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified time.Time
+}
+
+// the versions of the keys under a prefix, newest first for each key
+type ListVersionsResult struct {
+	Name, Prefix        string
+	KeyMarker           string
+	VersionIDMarker     string `xml:"VersionIdMarker"`
+	NextKeyMarker       string
+	NextVersionIDMarker string `xml:"NextVersionIdMarker"`
+	IsTruncated         bool
+	Versions            []ObjectVersion `xml:"Version"`
+	DeleteMarkers       []DeleteMarker  `xml:"DeleteMarker"`
+}
+
+// every version and delete marker of the keys under prefix in bucket
+func ListVersions(auth aws.Auth, bucket, prefix string) (ListVersionsResult, error) {
+	return NewClient(auth).ListVersions(context.Background(), bucket, prefix)
+}
+
+func (s SmartS3) ListVersions(ctx context.Context, bucket, prefix string) (ListVersionsResult, error) {
+	var out ListVersionsResult
+	var keyMarker, versionMarker string
+	for first := true; ; first = false {
+		page, err := s.listVersions(ctx, bucket, prefix, keyMarker, versionMarker)
+		if err != nil {
+			return out, err
+		}
+		if first {
+			out.Name, out.Prefix = page.Name, page.Prefix
+		}
+		out.Versions = append(out.Versions, page.Versions...)
+		out.DeleteMarkers = append(out.DeleteMarkers, page.DeleteMarkers...)
+		if !page.IsTruncated {
+			return out, nil
+		}
+		if page.NextKeyMarker == keyMarker && page.NextVersionIDMarker == versionMarker {
+			return out, fmt.Errorf("truncated version listing of %s made no progress past key %q", bucket, keyMarker)
+		}
+		keyMarker, versionMarker = page.NextKeyMarker, page.NextVersionIDMarker
+	}
+}
+
+// one page of versions, starting after the given key and version
+func (s SmartS3) listVersions(ctx context.Context, bucket, prefix, keyMarker, versionMarker string) (ListVersionsResult, error) {
+	u, resource, err := s.bucketURL(bucket)
+	if err != nil {
+		return ListVersionsResult{}, err
+	}
+	q := url.Values{"versions": {""}}
+	for k, v := range map[string]string{"prefix": prefix, "key-marker": keyMarker, "version-id-marker": versionMarker} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = joinQuery(q, url.QueryEscape)
+	resource += "?versions"
+	f := func(s SmartS3) (interface{}, error) {
+		var out ListVersionsResult
+		err := s.doXML(ctx, "GET", u, resource, nil, nil, &out)
+		return out, err
+	}
+	v, err := s.retry(ctx, bucket, fmt.Sprintf("list versions of %s/%s", bucket, prefix), f)
+	if err != nil {
+		return ListVersionsResult{}, err
+	}
+	return v.(ListVersionsResult), nil
+}
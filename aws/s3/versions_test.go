@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestGetVersion(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.Method+" "+r.URL.RawQuery)
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		io.WriteString(w, "old")
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	ctx := context.Background()
+	o := Object{"bkt", "k"}
+	if b, err := s.GetObjectContext(ctx, GetRequest{Object: o, VersionID: "3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY"}); err != nil || string(b) != "old" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	if err := s.DeleteContext(ctx, DeleteRequest{Object: o, VersionID: "3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetObjectContext(ctx, GetRequest{Object: o}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GET versionId=3HL4kqtJlcpXroDTDmJ%2BrmSpXd3dIbrHY", "DELETE versionId=3HL4kqtJlcpXroDTDmJ%2BrmSpXd3dIbrHY", "GET "}
+	if len(queries) != len(want) {
+		t.Fatalf("got %q", queries)
+	}
+	for i := range want {
+		if queries[i] != want[i] {
+			t.Errorf("request %d: got %q, want %q", i, queries[i], want[i])
+		}
+	}
+}
+
+func TestListVersions(t *testing.T) {
+	pages := map[string]string{
+		"": `<ListVersionsResult><Name>bkt</Name><Prefix>p</Prefix><IsTruncated>true</IsTruncated>
+<NextKeyMarker>p/a</NextKeyMarker><NextVersionIdMarker>v2</NextVersionIdMarker>
+<Version><Key>p/a</Key><VersionId>v3</VersionId><IsLatest>true</IsLatest><Size>3</Size></Version>
+<DeleteMarker><Key>p/a</Key><VersionId>v2</VersionId></DeleteMarker></ListVersionsResult>`,
+		"p/a v2": `<ListVersionsResult><Name>bkt</Name><Prefix>p</Prefix><IsTruncated>false</IsTruncated>
+<Version><Key>p/a</Key><VersionId>v1</VersionId><Size>1</Size></Version></ListVersionsResult>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if !q.Has("versions") || q.Get("prefix") != "p" {
+			t.Errorf("got %s", r.URL)
+		}
+		page, ok := pages[strings.TrimSpace(q.Get("key-marker")+" "+q.Get("version-id-marker"))]
+		if !ok {
+			t.Errorf("unexpected markers in %s", r.URL)
+		}
+		io.WriteString(w, page)
+	}))
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL}
+	res, err := s.ListVersions(context.Background(), "bkt", "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Versions) != 2 || res.Versions[0].VersionID != "v3" || !res.Versions[0].IsLatest || res.Versions[1].VersionID != "v1" {
+		t.Errorf("versions: got %+v", res.Versions)
+	}
+	if len(res.DeleteMarkers) != 1 || res.DeleteMarkers[0].VersionID != "v2" {
+		t.Errorf("delete markers: got %+v", res.DeleteMarkers)
+	}
+	if res.Name != "bkt" || res.IsTruncated {
+		t.Errorf("got %+v", res)
+	}
+}
@@ -0,0 +1,75 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/xoba/goutil/aws"
+)
+
+// an io.WriteCloser streaming into an object, as a multipart upload once
+// there's more than a part's worth. see SmartS3.PutWriter.
+type ObjectWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	res  PutResult
+	err  error
+}
+
+// a writer into o, which exists once the writer is closed without error
+func PutWriter(auth aws.Auth, o Object) *ObjectWriter {
+	return NewClient(auth).PutWriter(context.Background(), o, UploadOptions{})
+}
+
+// a writer into o. what's written is buffered a part at a time, per opts, and
+// each part sent as it fills, Concurrency at once; Write blocks while that
+// many are under way. Close sends the rest and completes the upload, or puts
+// the object whole if it never filled a part. CloseWithError, or a failure to
+// send a part, aborts it, after which writes fail.
+func (s SmartS3) PutWriter(ctx context.Context, o Object, opts UploadOptions) *ObjectWriter {
+	pr, pw := io.Pipe()
+	w := &ObjectWriter{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		w.res, w.err = s.UploadStream(ctx, o, pr, opts)
+		if w.err != nil {
+			pr.CloseWithError(w.err)
+		} else {
+			pr.Close()
+		}
+	}()
+	return w
+}
+
+func (w *ObjectWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// completes the upload, returning any error from it
+func (w *ObjectWriter) Close() error {
+	w.pw.Close()
+	<-w.done
+	return w.err
+}
+
+// abandons the upload, aborting it if it's multipart. the error is nil
+// unless the abort failed, or the upload had already failed some other way.
+func (w *ObjectWriter) CloseWithError(err error) error {
+	if err == nil {
+		err = errors.New("upload abandoned")
+	}
+	w.pw.CloseWithError(err)
+	<-w.done
+	// UploadStream returns err itself when it stopped for it and aborted
+	// cleanly, and wraps it when the abort failed too
+	if w.err == nil || w.err == err {
+		return nil
+	}
+	return w.err
+}
+
+// what the upload gave, once Close has returned without error
+func (w *ObjectWriter) Result() PutResult {
+	return w.res
+}
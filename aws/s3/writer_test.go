@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/xoba/goutil/aws"
+)
+
+func TestPutWriter(t *testing.T) {
+	m, srv := newMultipartServer()
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, PathStyle: true}
+	ctx := context.Background()
+
+	var want bytes.Buffer
+	w := s.PutWriter(ctx, Object{"bkt", "big"}, UploadOptions{})
+	chunk := make([]byte, 1000)
+	for want.Len() < 12<<20 {
+		for i := range chunk {
+			chunk[i] = byte(want.Len() + i*7)
+		}
+		want.Write(chunk)
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.objects["/bkt/big"], want.Bytes()) {
+		t.Fatalf("got %d bytes, want %d", len(m.objects["/bkt/big"]), want.Len())
+	}
+	if len(m.parts) != 2 || w.Result().ETag != `"done"` {
+		t.Errorf("%d parts, result %v", len(m.parts), w.Result())
+	}
+
+	w = s.PutWriter(ctx, Object{"bkt", "small"}, UploadOptions{})
+	io.WriteString(w, "hi")
+	if err := w.Close(); err != nil || string(m.objects["/bkt/small"]) != "hi" {
+		t.Fatal(err, m.objects["/bkt/small"])
+	}
+}
+
+func TestPutWriterCloseWithError(t *testing.T) {
+	m, srv := newMultipartServer()
+	defer srv.Close()
+	s := SmartS3{Auth: aws.Auth{AccessKey: "a", SecretKey: "b"}, Endpoint: srv.URL, PathStyle: true}
+	ctx := context.Background()
+
+	w := s.PutWriter(ctx, Object{"bkt", "k"}, UploadOptions{})
+	w.Write(make([]byte, 10<<20))
+	if err := w.CloseWithError(errors.New("changed my mind")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("y")); err == nil {
+		t.Error("write after CloseWithError succeeded")
+	}
+	if _, ok := m.objects["/bkt/k"]; ok || m.aborts != 1 {
+		t.Fatalf("object made, or %d aborts", m.aborts)
+	}
+
+	m.failAbort = true
+	w = s.PutWriter(ctx, Object{"bkt", "k"}, UploadOptions{})
+	w.Write(make([]byte, 10<<20))
+	cause := errors.New("changed my mind")
+	err := w.CloseWithError(cause)
+	if err == nil || !errors.Is(err, cause) {
+		t.Fatalf("a failed abort gave %v", err)
+	}
+}
@@ -18,13 +18,11 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -37,8 +35,6 @@ var (
 	ErrNoDate = errors.New("Date header not supplied")
 )
 
-var lf = []byte{'\n'}
-
 // Keys holds a set of Amazon Security Credentials.
 type Keys struct {
 	AccessKey string
@@ -80,132 +76,107 @@ func (s *Service) Sign(keys *Keys, r *http.Request) error {
 		return err
 	}
 
-	r.Header.Set("Date", t.Format(iSO8601BasicFormat))
-
-	k := keys.sign(s, t)
-	h := hmac.New(sha256.New, k)
-	s.writeStringToSign(h, t, r)
+	payload, err := hashBody(r)
+	if err != nil {
+		return err
+	}
 
-	auth := bytes.NewBufferString("AWS4-HMAC-SHA256 ")
-	auth.Write([]byte("Credential=" + keys.AccessKey + "/" + s.creds(t)))
-	auth.Write([]byte{',', ' '})
-	auth.Write([]byte("SignedHeaders="))
-	s.writeHeaderList(auth, r)
-	auth.Write([]byte{',', ' '})
-	auth.Write([]byte("Signature=" + fmt.Sprintf("%x", h.Sum(nil))))
+	r.Header.Set("Date", t.Format(iSO8601BasicFormat))
 
-	r.Header.Set("Authorization", auth.String())
+	_, err = s.SignHashed(keys, r, payload, t)
+	return err
+}
 
-	return nil
+// SignHashed signs r, dated t, for use on service s, where payload is the hex
+// sha256 of its body, or a placeholder the service accepts in its place, such
+// as s3's UNSIGNED-PAYLOAD. Every header already on r is signed, and r's body
+// is left unread. It returns the string that was signed.
+func (s *Service) SignHashed(keys *Keys, r *http.Request, payload string, t time.Time) (string, error) {
+	t = t.UTC()
+	canonical, signed := canonicalRequest(r, payload)
+	h := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + t.Format(iSO8601BasicFormat) + "\n" + s.creds(t) + "\n" + hex.EncodeToString(h[:])
+
+	sig := ghmac(keys.sign(s, t), []byte(toSign))
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keys.AccessKey+"/"+s.creds(t)+
+		",SignedHeaders="+signed+",Signature="+hex.EncodeToString(sig))
+
+	return toSign, nil
 }
 
-func (s *Service) writeQuery(w io.Writer, r *http.Request) {
-	var a []string
-	for k, vs := range r.URL.Query() {
-		k = url.QueryEscape(k)
-		for _, v := range vs {
-			if v == "" {
-				a = append(a, k)
-			} else {
-				v = url.QueryEscape(v)
-				a = append(a, k+"="+v)
-			}
-		}
-	}
-	sort.Strings(a)
-	for i, s := range a {
-		if i > 0 {
-			w.Write([]byte{'&'})
+func hashBody(r *http.Request) (string, error) {
+	h := sha256.New()
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", err
 		}
-		w.Write([]byte(s))
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(b))
+		h.Write(b)
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (s *Service) writeHeader(w io.Writer, r *http.Request) {
-	i, a := 0, make([]string, len(r.Header))
-	for k, v := range r.Header {
-		sort.Strings(v)
-		a[i] = strings.ToLower(k) + ":" + strings.Join(v, ",")
-		i++
+// canonicalRequest returns the canonical form of r and the
+// semicolon-separated names of the headers it signs, which are all of them
+// but Authorization, plus host.
+func canonicalRequest(r *http.Request, payload string) (string, string) {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
 	}
-	sort.Strings(a)
-	for i, s := range a {
-		if i > 0 {
-			w.Write(lf)
+	headers := map[string]string{"host": host}
+	for k, vs := range r.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" {
+			continue
+		}
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
 		}
-		io.WriteString(w, s)
+		headers[k] = strings.Join(trimmed, ",")
 	}
-}
-
-func (s *Service) writeHeaderList(w io.Writer, r *http.Request) {
-	i, a := 0, make([]string, len(r.Header))
-	for k, _ := range r.Header {
-		a[i] = strings.ToLower(k)
-		i++
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
 	}
-	sort.Strings(a)
-	for i, s := range a {
-		if i > 0 {
-			w.Write([]byte{';'})
-		}
-		w.Write([]byte(s))
+	sort.Strings(names)
+	var ch bytes.Buffer
+	for _, k := range names {
+		ch.WriteString(k + ":" + headers[k] + "\n")
 	}
-}
+	signed := strings.Join(names, ";")
 
-func (s *Service) writeBody(w io.Writer, r *http.Request) {
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		panic(err)
+	// the path is signed as sent, never cleaned: s3 keys may hold "//" or "."
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
 	}
-	r.Body = ioutil.NopCloser(bytes.NewBuffer(b))
-
-	h := sha256.New()
-	h.Write(b)
-	fmt.Fprintf(w, "%x", h.Sum(nil))
+	return r.Method + "\n" + path + "\n" + canonicalQuery(r.URL.Query()) + "\n" + ch.String() + "\n" + signed + "\n" + payload, signed
 }
 
-func (s *Service) writeURI(w io.Writer, r *http.Request) {
-	path := r.URL.RequestURI()
-	if r.URL.RawQuery != "" {
-		path = path[:len(path)-len(r.URL.RawQuery)-1]
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
 	}
-	slash := strings.HasSuffix(path, "/")
-	path = filepath.Clean(path)
-	if path != "/" && slash {
-		path += "/"
+	sort.Strings(keys)
+	var a []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			a = append(a, URIEncode(k)+"="+URIEncode(v))
+		}
 	}
-	w.Write([]byte(path))
-}
-
-func (s *Service) writeRequest(w io.Writer, r *http.Request) {
-	r.Header.Set("host", r.Host)
-
-	w.Write([]byte(r.Method))
-	w.Write(lf)
-	s.writeURI(w, r)
-	w.Write(lf)
-	s.writeQuery(w, r)
-	w.Write(lf)
-	s.writeHeader(w, r)
-	w.Write(lf)
-	w.Write(lf)
-	s.writeHeaderList(w, r)
-	w.Write(lf)
-	s.writeBody(w, r)
+	return strings.Join(a, "&")
 }
 
-func (s *Service) writeStringToSign(w io.Writer, t time.Time, r *http.Request) {
-	w.Write([]byte("AWS4-HMAC-SHA256"))
-	w.Write(lf)
-	w.Write([]byte(t.Format(iSO8601BasicFormat)))
-	w.Write(lf)
-
-	w.Write([]byte(s.creds(t)))
-	w.Write(lf)
-
-	h := sha256.New()
-	s.writeRequest(h, r)
-	fmt.Fprintf(w, "%x", h.Sum(nil))
+// URIEncode escapes s as signature version 4 requires: everything but the
+// unreserved characters of rfc 3986.
+func URIEncode(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
 }
 
 func (s *Service) creds(t time.Time) string {
@@ -0,0 +1,55 @@
+package aws4
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the get-vanilla case of aws's signature version 4 test suite
+func TestSignHashedVector(t *testing.T) {
+	r, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Amz-Date", "20150830T123600Z")
+	when, _ := time.Parse(iSO8601BasicFormat, "20150830T123600Z")
+	svc := Service{Name: "service", Region: "us-east-1"}
+	keys := Keys{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	const empty = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if _, err := svc.SignHashed(&keys, r, empty, when); err != nil {
+		t.Fatal(err)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request,SignedHeaders=host;x-amz-date,Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := r.Header.Get("Authorization"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCanonicalRequestKeepsPath(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://b.s3.amazonaws.com/a//b/./c%20d?acl&prefix=x+y", nil)
+	c, _ := canonicalRequest(r, "p")
+	lines := strings.Split(c, "\n")
+	if lines[1] != "/a//b/./c%20d" {
+		t.Errorf("path: got %q", lines[1])
+	}
+	if lines[2] != "acl=&prefix=x%20y" {
+		t.Errorf("query: got %q", lines[2])
+	}
+}
+
+func TestSignNeedsDate(t *testing.T) {
+	r, _ := http.NewRequest("POST", "https://sts.amazonaws.com/", strings.NewReader("x"))
+	svc := Service{Name: "sts", Region: "us-east-1"}
+	if err := svc.Sign(&Keys{AccessKey: "a", SecretKey: "b"}, r); err != ErrNoDate {
+		t.Fatalf("got %v, want ErrNoDate", err)
+	}
+	r.Header.Set("Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+	if err := svc.Sign(&Keys{AccessKey: "a", SecretKey: "b"}, r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Get("Date") != "20060102T150405Z" || !strings.Contains(r.Header.Get("Authorization"), "SignedHeaders=date;host,") {
+		t.Errorf("got %v", r.Header)
+	}
+}
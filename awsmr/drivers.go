@@ -42,7 +42,7 @@ func (d BucketDriver) Drive(output chan<- mr.KeyValue) {
 }
 
 func fetchFromS3(ss3 s3.Interface, bucket, key string, input chan<- mr.KeyValue) error {
-	buf, err := ss3.GetObject(s3.GetRequest{s3.Object{Bucket: bucket, Key: key}})
+	buf, err := ss3.GetObject(s3.GetRequest{Object: s3.Object{Bucket: bucket, Key: key}})
 	if err != nil {
 		return err
 	}